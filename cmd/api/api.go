@@ -1,13 +1,27 @@
 package api
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/duziem/ecommerce_proj/configs"
+	"github.com/duziem/ecommerce_proj/services/auth"
 	"github.com/duziem/ecommerce_proj/services/cart"
+	"github.com/duziem/ecommerce_proj/services/category"
+	"github.com/duziem/ecommerce_proj/services/idempotency"
+	"github.com/duziem/ecommerce_proj/services/inventory"
+	"github.com/duziem/ecommerce_proj/services/mail"
+	"github.com/duziem/ecommerce_proj/services/media"
 	"github.com/duziem/ecommerce_proj/services/order"
+	"github.com/duziem/ecommerce_proj/services/payments"
 	"github.com/duziem/ecommerce_proj/services/product"
+	"github.com/duziem/ecommerce_proj/services/promotion"
+	"github.com/duziem/ecommerce_proj/services/rbac"
+	"github.com/duziem/ecommerce_proj/services/returns"
 	"github.com/duziem/ecommerce_proj/services/user"
 	"github.com/gorilla/mux"
 )
@@ -28,22 +42,78 @@ func (s *APIServer) Run() error {
 	router := mux.NewRouter()
 	subrouter := router.PathPrefix("/api/v1").Subrouter()
 
+	keys, err := auth.LoadKeys(configs.Envs)
+	if err != nil {
+		return err
+	}
+	auth.SetKeys(keys)
+
+	mailer, err := newMailer()
+	if err != nil {
+		return err
+	}
+
+	// the JWKS is published at the root like other well-known URIs
+	authHandler := auth.NewHandler(keys)
+	authHandler.RegisterRoutes(router)
+
 	userStore := user.NewStore(s.db)
-	userHandler := user.NewHandler(userStore)
+	cartStore := cart.NewStore(s.db)
+	mailStore := mail.NewStore(s.db)
+	userHandler := user.NewHandler(userStore, cartStore, mailStore)
 	userHandler.RegisterRoutes(subrouter)
 
+	rbacStore := rbac.NewStore(s.db)
+	rbacHandler := rbac.NewHandler(rbacStore, userStore)
+	rbacHandler.RegisterRoutes(subrouter)
+
 	productStore := product.NewStore(s.db)
-	productHandler := product.NewHandler(productStore, userStore)
+	categoryStore := category.NewStore(s.db)
+	mediaStorage := media.NewLocalStorage(configs.Envs.MediaDir, configs.Envs.MediaBaseURL)
+	productHandler := product.NewHandler(productStore, categoryStore, userStore, mediaStorage)
 	productHandler.RegisterRoutes(subrouter)
 
+	categoryHandler := category.NewHandler(categoryStore, productStore, userStore)
+	categoryHandler.RegisterRoutes(subrouter)
+
 	orderStore := order.NewStore(s.db)
 
-	cartHandler := cart.NewHandler(productStore, orderStore, userStore)
+	idempotencyStore := idempotency.NewStore(s.db)
+
+	promotionStore := promotion.NewStore(s.db)
+	promotionHandler := promotion.NewHandler(promotionStore, productStore, userStore)
+	promotionHandler.RegisterRoutes(subrouter)
+
+	paymentStore := payments.NewStore(s.db)
+	paymentService := payments.NewService(
+		paymentStore, orderStore, productStore, configs.Envs.PaymentProvider,
+		payments.NewMockProvider(configs.Envs.MockPaymentWebhookSecret),
+	)
+	paymentHandler := payments.NewHandler(paymentService)
+	paymentHandler.RegisterRoutes(subrouter)
+
+	cartHandler := cart.NewHandler(productStore, cartStore, orderStore, userStore, idempotencyStore, promotionStore, paymentService)
 	cartHandler.RegisterRoutes(subrouter)
 
-	orderHandler := order.NewHandler(orderStore, userStore)
+	orderHandler := order.NewHandler(orderStore, productStore, userStore, paymentService)
 	orderHandler.RegisterRoutes(subrouter)
 
+	returnStore := returns.NewStore(s.db)
+	returnHandler := returns.NewHandler(returnStore, orderStore, productStore, userStore, paymentService)
+	returnHandler.RegisterRoutes(subrouter)
+
+	// Release the stock held by orders that were never paid for
+	sweeper := inventory.NewSweeper(orderStore, productStore, time.Duration(configs.Envs.ReservationSweepInSeconds)*time.Second)
+	go sweeper.Run(context.Background())
+
+	// Send the emails in the outbox
+	mailWorker := mail.NewWorker(mailStore, mailer, time.Duration(configs.Envs.MailWorkerInSeconds)*time.Second)
+	go mailWorker.Run(context.Background())
+
+	// Serve uploaded images, registered before the static files which would shadow them
+	mediaHandler := media.NewHandler(mediaStorage, "/media/")
+	mediaHandler.RegisterRoutes(router)
+
 	// Serve static files
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("static")))
 
@@ -51,3 +121,17 @@ func (s *APIServer) Run() error {
 
 	return http.ListenAndServe(s.addr, router)
 }
+
+// newMailer picks the mail transport from MAIL_TRANSPORT, "smtp" or "log".
+// Any other value is refused rather than falling back to logging the emails,
+// which would leak the tokens they carry.
+func newMailer() (mail.Mailer, error) {
+	switch configs.Envs.MailTransport {
+	case "smtp":
+		return mail.NewSMTPMailer(configs.Envs.SMTPHost, configs.Envs.SMTPPort, configs.Envs.SMTPUsername, configs.Envs.SMTPPassword, configs.Envs.MailFrom), nil
+	case "log":
+		return mail.NewLogMailer(configs.Envs.MailDir, configs.Envs.MailFrom), nil
+	}
+
+	return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q, expected smtp or log", configs.Envs.MailTransport)
+}
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS categories (
+  id SERIAL PRIMARY KEY,
+  parentId INT,
+  name VARCHAR(255) NOT NULL,
+  slug VARCHAR(255) NOT NULL UNIQUE,
+  description TEXT NOT NULL DEFAULT '',
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  FOREIGN KEY (parentId) REFERENCES categories(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_categories_parent_id ON categories (parentId);
+
+CREATE TABLE IF NOT EXISTS product_categories (
+  productId INT NOT NULL,
+  categoryId INT NOT NULL,
+
+  PRIMARY KEY (productId, categoryId),
+  FOREIGN KEY (productId) REFERENCES products(id) ON DELETE CASCADE,
+  FOREIGN KEY (categoryId) REFERENCES categories(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_categories_category_id ON product_categories (categoryId);
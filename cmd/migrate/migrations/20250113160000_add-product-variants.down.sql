@@ -0,0 +1,4 @@
+ALTER TABLE order_items DROP COLUMN IF EXISTS variantId;
+
+DROP TABLE IF EXISTS product_variants;
+DROP TABLE IF EXISTS product_options;
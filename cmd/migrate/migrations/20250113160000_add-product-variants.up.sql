@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS product_options (
+  id SERIAL PRIMARY KEY,
+  productId INT NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  optionValues TEXT[] NOT NULL DEFAULT '{}',
+  position INT NOT NULL DEFAULT 0,
+
+  UNIQUE (productId, name),
+  FOREIGN KEY (productId) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS product_variants (
+  id SERIAL PRIMARY KEY,
+  productId INT NOT NULL,
+  sku VARCHAR(100) NOT NULL UNIQUE,
+  -- overrides products.price when set
+  price DECIMAL(10, 2),
+  quantity INT NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+  options JSONB NOT NULL DEFAULT '{}',
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  FOREIGN KEY (productId) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_variants_product_id ON product_variants (productId);
+
+-- every existing product gets a single variant holding its current stock
+INSERT INTO product_variants (productId, sku, quantity)
+SELECT id, 'SKU-' || id, GREATEST(quantity, 0) FROM products;
+
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS variantId INT;
+ALTER TABLE order_items ADD FOREIGN KEY (variantId) REFERENCES product_variants(id) ON DELETE SET NULL;
+
+UPDATE order_items
+SET variantId = product_variants.id
+FROM product_variants
+WHERE product_variants.productId = order_items.productId;
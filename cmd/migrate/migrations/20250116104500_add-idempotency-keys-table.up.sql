@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  userId INT NOT NULL,
+  key VARCHAR(255) NOT NULL,
+  requestHash VARCHAR(64) NOT NULL,
+  -- NULL while the first request with this key is still being processed
+  statusCode INT,
+  responseBody BYTEA,
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expiresAt TIMESTAMP NOT NULL,
+
+  PRIMARY KEY (userId, key),
+  FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys (expiresAt);
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS carts (
+  id SERIAL PRIMARY KEY,
+  userId INT NOT NULL UNIQUE,
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+  id SERIAL PRIMARY KEY,
+  cartId INT NOT NULL,
+  productId INT NOT NULL,
+  variantId INT NOT NULL,
+  quantity INT NOT NULL CHECK (quantity > 0),
+  -- unit price when the item was added, to warn about price changes
+  price DECIMAL(10, 2) NOT NULL,
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE (cartId, variantId),
+  FOREIGN KEY (cartId) REFERENCES carts(id) ON DELETE CASCADE,
+  FOREIGN KEY (productId) REFERENCES products(id) ON DELETE CASCADE,
+  FOREIGN KEY (variantId) REFERENCES product_variants(id) ON DELETE CASCADE
+);
@@ -0,0 +1,5 @@
+DELETE FROM carts WHERE userId IS NULL;
+
+ALTER TABLE carts DROP CONSTRAINT IF EXISTS carts_owner_check;
+ALTER TABLE carts DROP COLUMN IF EXISTS tokenHash;
+ALTER TABLE carts ALTER COLUMN userId SET NOT NULL;
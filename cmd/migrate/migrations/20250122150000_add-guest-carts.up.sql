@@ -0,0 +1,4 @@
+-- guest carts have no user and are found by the hash of their opaque token
+ALTER TABLE carts ALTER COLUMN userId DROP NOT NULL;
+ALTER TABLE carts ADD COLUMN IF NOT EXISTS tokenHash VARCHAR(64) UNIQUE;
+ALTER TABLE carts ADD CONSTRAINT carts_owner_check CHECK (userId IS NOT NULL OR tokenHash IS NOT NULL);
@@ -0,0 +1,8 @@
+ALTER TABLE order_items DROP COLUMN IF EXISTS discount;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS promotionId;
+ALTER TABLE orders DROP COLUMN IF EXISTS shippingTotal;
+ALTER TABLE orders DROP COLUMN IF EXISTS discount;
+
+DROP TABLE IF EXISTS promotion_redemptions;
+DROP TABLE IF EXISTS promotions;
@@ -0,0 +1,41 @@
+CREATE TABLE IF NOT EXISTS promotions (
+  id SERIAL PRIMARY KEY,
+  -- codes are stored upper case and matched case-insensitively
+  code VARCHAR(64) NOT NULL UNIQUE,
+  description TEXT NOT NULL DEFAULT '',
+  type VARCHAR(20) NOT NULL CHECK (type IN ('percentage', 'fixed_amount', 'free_shipping', 'buy_x_get_y')),
+  percentage INT CHECK (percentage BETWEEN 1 AND 100),
+  amount DECIMAL(10, 2),
+  buyQuantity INT CHECK (buyQuantity > 0),
+  getQuantity INT CHECK (getQuantity > 0),
+  productIds INT[] NOT NULL DEFAULT '{}',
+  minOrderValue DECIMAL(10, 2),
+  usageLimit INT,
+  perUserLimit INT,
+  timesUsed INT NOT NULL DEFAULT 0,
+  startsAt TIMESTAMP,
+  endsAt TIMESTAMP,
+  active BOOLEAN NOT NULL DEFAULT TRUE,
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS promotion_redemptions (
+  id SERIAL PRIMARY KEY,
+  promotionId INT NOT NULL,
+  userId INT NOT NULL,
+  orderId INT NOT NULL,
+  discount DECIMAL(10, 2) NOT NULL,
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  FOREIGN KEY (promotionId) REFERENCES promotions(id),
+  FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (orderId) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_promotion_redemptions_promotion_user ON promotion_redemptions (promotionId, userId);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount DECIMAL(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shippingTotal DECIMAL(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS promotionId INT REFERENCES promotions(id) ON DELETE SET NULL;
+
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS discount DECIMAL(10, 2) NOT NULL DEFAULT 0;
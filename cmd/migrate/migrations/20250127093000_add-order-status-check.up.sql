@@ -0,0 +1,4 @@
+-- NOT VALID leaves rows written before the constraint alone, new and updated
+-- rows are checked
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+  CHECK (status IN ('pending', 'paid', 'processing', 'shipped', 'delivered', 'cancelled', 'refunded')) NOT VALID;
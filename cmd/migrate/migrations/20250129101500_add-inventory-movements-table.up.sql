@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS inventory_movements (
+  id SERIAL PRIMARY KEY,
+  -- no foreign key on productId so the history outlives deleted products
+  productId INT NOT NULL,
+  variantId INT,
+  orderId INT,
+  -- positive when stock is added, negative when it is taken
+  quantity INT NOT NULL,
+  reason VARCHAR(32) NOT NULL,
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  FOREIGN KEY (variantId) REFERENCES product_variants(id) ON DELETE SET NULL,
+  FOREIGN KEY (orderId) REFERENCES orders(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_inventory_movements_product_id ON inventory_movements (productId, createdAt);
+CREATE INDEX IF NOT EXISTS idx_inventory_movements_order_id ON inventory_movements (orderId);
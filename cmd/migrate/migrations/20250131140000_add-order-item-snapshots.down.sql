@@ -0,0 +1,5 @@
+ALTER TABLE order_items DROP COLUMN IF EXISTS createdAt;
+ALTER TABLE order_items DROP COLUMN IF EXISTS options;
+ALTER TABLE order_items DROP COLUMN IF EXISTS sku;
+ALTER TABLE order_items DROP COLUMN IF EXISTS productImage;
+ALTER TABLE order_items DROP COLUMN IF EXISTS productName;
@@ -0,0 +1,22 @@
+-- a copy of the product as it was bought, so later edits don't rewrite history
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS productName VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS productImage VARCHAR(255);
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS sku VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS options JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+-- existing items get the best snapshot still available
+UPDATE order_items oi
+SET productName = p.name, productImage = p.image
+FROM products p
+WHERE p.id = oi.productId;
+
+UPDATE order_items oi
+SET sku = v.sku, options = v.options
+FROM product_variants v
+WHERE v.id = oi.variantId;
+
+UPDATE order_items oi
+SET createdAt = o.createdAt
+FROM orders o
+WHERE o.id = oi.orderId;
@@ -0,0 +1,7 @@
+CREATE INDEX IF NOT EXISTS idx_orders_created_at ON orders (createdAt, id);
+CREATE INDEX IF NOT EXISTS idx_orders_total ON orders (total, id);
+CREATE INDEX IF NOT EXISTS idx_orders_status ON orders (status, createdAt);
+CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders (userId, createdAt);
+
+CREATE INDEX IF NOT EXISTS idx_order_items_order_id ON order_items (orderId);
+CREATE INDEX IF NOT EXISTS idx_order_items_product_id ON order_items (productId);
@@ -0,0 +1,7 @@
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+  CHECK (status IN ('pending', 'paid', 'processing', 'shipped', 'delivered', 'cancelled', 'refunded')) NOT VALID;
+
+DROP TABLE IF EXISTS refunds;
+DROP TABLE IF EXISTS return_items;
+DROP TABLE IF EXISTS returns;
@@ -0,0 +1,48 @@
+CREATE TABLE IF NOT EXISTS returns (
+  id SERIAL PRIMARY KEY,
+  orderId INT NOT NULL,
+  userId INT NOT NULL,
+  status VARCHAR(20) NOT NULL DEFAULT 'requested' CHECK (status IN ('requested', 'approved', 'rejected', 'received')),
+  reason TEXT NOT NULL,
+  adminNote TEXT NOT NULL DEFAULT '',
+  refundAmount DECIMAL(10, 2) NOT NULL,
+  restocked BOOLEAN NOT NULL DEFAULT FALSE,
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  FOREIGN KEY (orderId) REFERENCES orders(id) ON DELETE CASCADE,
+  FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_returns_order_id ON returns (orderId);
+CREATE INDEX IF NOT EXISTS idx_returns_status ON returns (status, createdAt);
+
+CREATE TABLE IF NOT EXISTS return_items (
+  id SERIAL PRIMARY KEY,
+  returnId INT NOT NULL,
+  orderItemId INT NOT NULL,
+  quantity INT NOT NULL CHECK (quantity > 0),
+  refundAmount DECIMAL(10, 2) NOT NULL,
+
+  FOREIGN KEY (returnId) REFERENCES returns(id) ON DELETE CASCADE,
+  FOREIGN KEY (orderItemId) REFERENCES order_items(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_return_items_return_id ON return_items (returnId);
+
+CREATE TABLE IF NOT EXISTS refunds (
+  id SERIAL PRIMARY KEY,
+  orderId INT NOT NULL,
+  returnId INT,
+  amount DECIMAL(10, 2) NOT NULL CHECK (amount >= 0),
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  FOREIGN KEY (orderId) REFERENCES orders(id) ON DELETE CASCADE,
+  FOREIGN KEY (returnId) REFERENCES returns(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_order_id ON refunds (orderId);
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+  CHECK (status IN ('pending', 'paid', 'processing', 'shipped', 'delivered', 'cancelled', 'refunded', 'partially_refunded')) NOT VALID;
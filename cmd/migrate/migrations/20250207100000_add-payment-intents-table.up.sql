@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS payment_intents (
+  id SERIAL PRIMARY KEY,
+  orderId INT NOT NULL,
+  provider VARCHAR(32) NOT NULL,
+  providerRef VARCHAR(255),
+  status VARCHAR(20) NOT NULL DEFAULT 'created'
+    CHECK (status IN ('created', 'authorized', 'captured', 'voided', 'failed', 'partially_refunded', 'refunded')),
+  amount DECIMAL(10, 2) NOT NULL,
+  refundedAmount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+  lastError TEXT NOT NULL DEFAULT '',
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE (provider, providerRef),
+  FOREIGN KEY (orderId) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_intents_order_id ON payment_intents (orderId);
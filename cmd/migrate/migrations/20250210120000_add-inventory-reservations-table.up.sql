@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS inventory_reservations (
+  id SERIAL PRIMARY KEY,
+  orderId INT NOT NULL,
+  productId INT NOT NULL,
+  variantId INT NOT NULL,
+  quantity INT NOT NULL CHECK (quantity > 0),
+  status VARCHAR(20) NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'committed', 'released')),
+  expiresAt TIMESTAMP NOT NULL,
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  FOREIGN KEY (orderId) REFERENCES orders(id) ON DELETE CASCADE,
+  FOREIGN KEY (variantId) REFERENCES product_variants(id) ON DELETE CASCADE
+);
+
+-- only active reservations count against stock or need sweeping
+CREATE INDEX IF NOT EXISTS idx_inventory_reservations_variant_id ON inventory_reservations (variantId) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_inventory_reservations_product_id ON inventory_reservations (productId) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_inventory_reservations_expires_at ON inventory_reservations (expiresAt) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_inventory_reservations_order_id ON inventory_reservations (orderId);
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS inventory_movements_append_only ON inventory_movements;
+DROP FUNCTION IF EXISTS inventory_movements_append_only();
+
+ALTER TABLE inventory_movements DROP CONSTRAINT IF EXISTS inventory_movements_reason_check;
+
+DELETE FROM inventory_movements WHERE reason = 'initial';
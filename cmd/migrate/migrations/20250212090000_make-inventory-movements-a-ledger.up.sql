@@ -0,0 +1,34 @@
+-- open the ledger with the stock each variant already has, so that stock
+-- always equals the sum of its movements
+INSERT INTO inventory_movements (productId, variantId, quantity, reason)
+SELECT v.productId, v.id, v.quantity - COALESCE(SUM(m.quantity), 0), 'initial'
+FROM product_variants v
+LEFT JOIN inventory_movements m ON m.variantId = v.id
+GROUP BY v.id, v.productId, v.quantity
+HAVING v.quantity - COALESCE(SUM(m.quantity), 0) <> 0;
+
+ALTER TABLE inventory_movements ADD CONSTRAINT inventory_movements_reason_check
+  CHECK (reason IN ('initial', 'sale', 'cancellation', 'manual_adjust', 'return', 'import'));
+
+-- movements can't be changed or removed, except for the references that
+-- ON DELETE SET NULL clears when a variant or order is deleted
+CREATE OR REPLACE FUNCTION inventory_movements_append_only() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'UPDATE'
+    AND NEW.id = OLD.id
+    AND NEW.productId = OLD.productId
+    AND NEW.quantity = OLD.quantity
+    AND NEW.reason = OLD.reason
+    AND NEW.createdAt = OLD.createdAt
+    AND (NEW.variantId IS NULL OR NEW.variantId = OLD.variantId)
+    AND (NEW.orderId IS NULL OR NEW.orderId = OLD.orderId) THEN
+    RETURN NEW;
+  END IF;
+
+  RAISE EXCEPTION 'inventory_movements is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER inventory_movements_append_only
+  BEFORE UPDATE OR DELETE ON inventory_movements
+  FOR EACH ROW EXECUTE FUNCTION inventory_movements_append_only();
@@ -0,0 +1,7 @@
+ALTER TABLE order_items DROP CONSTRAINT IF EXISTS order_items_productid_fkey;
+ALTER TABLE order_items ADD CONSTRAINT order_items_productid_fkey
+  FOREIGN KEY (productId) REFERENCES products(id) ON DELETE CASCADE;
+
+DROP INDEX IF EXISTS idx_products_deleted_at;
+
+ALTER TABLE products DROP COLUMN IF EXISTS deletedAt;
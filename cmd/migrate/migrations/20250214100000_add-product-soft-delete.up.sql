@@ -0,0 +1,9 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS deletedAt TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_products_deleted_at ON products (deletedAt) WHERE deletedAt IS NOT NULL;
+
+-- deleting a product used to erase its order lines, products that were
+-- ordered are now archived instead and can't be deleted
+ALTER TABLE order_items DROP CONSTRAINT IF EXISTS order_items_productid_fkey;
+ALTER TABLE order_items ADD CONSTRAINT order_items_productid_fkey
+  FOREIGN KEY (productId) REFERENCES products(id) ON DELETE RESTRICT;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS product_images (
+  id SERIAL PRIMARY KEY,
+  productId INT NOT NULL,
+  position INT NOT NULL,
+  storageKey VARCHAR(255) NOT NULL,
+  thumbnailKeys JSONB NOT NULL DEFAULT '{}',
+  contentType VARCHAR(50) NOT NULL,
+  width INT NOT NULL,
+  height INT NOT NULL,
+  size BIGINT NOT NULL,
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  -- deferred so images can swap positions when they are reordered
+  UNIQUE (productId, position) DEFERRABLE INITIALLY DEFERRED,
+  FOREIGN KEY (productId) REFERENCES products(id) ON DELETE CASCADE
+);
@@ -0,0 +1,27 @@
+-- a session is a login, the family of refresh tokens rotated from it
+CREATE TABLE IF NOT EXISTS sessions (
+  id SERIAL PRIMARY KEY,
+  userId INT NOT NULL,
+  expiresAt TIMESTAMP NOT NULL,
+  revokedAt TIMESTAMP,
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (userId) WHERE revokedAt IS NULL;
+
+-- only the sha256 of a refresh token is stored, usedAt is set when it is
+-- rotated and presenting it again revokes the session
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  id SERIAL PRIMARY KEY,
+  sessionId INT NOT NULL,
+  tokenHash CHAR(64) NOT NULL UNIQUE,
+  expiresAt TIMESTAMP NOT NULL,
+  usedAt TIMESTAMP,
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  FOREIGN KEY (sessionId) REFERENCES sessions(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens (sessionId);
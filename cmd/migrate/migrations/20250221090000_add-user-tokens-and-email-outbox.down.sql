@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS email_outbox;
+DROP TABLE IF EXISTS user_tokens;
+ALTER TABLE users DROP COLUMN IF EXISTS emailVerifiedAt;
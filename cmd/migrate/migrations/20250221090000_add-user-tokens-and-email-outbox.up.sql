@@ -0,0 +1,34 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS emailVerifiedAt TIMESTAMP;
+
+-- single-use tokens mailed to users, only their sha256 is stored
+CREATE TABLE IF NOT EXISTS user_tokens (
+  id SERIAL PRIMARY KEY,
+  userId INT NOT NULL,
+  purpose VARCHAR(30) NOT NULL CHECK (purpose IN ('password_reset', 'email_verification')),
+  tokenHash CHAR(64) NOT NULL UNIQUE,
+  expiresAt TIMESTAMP NOT NULL,
+  usedAt TIMESTAMP,
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON user_tokens (userId) WHERE usedAt IS NULL;
+
+-- emails are written here in the same transaction as the change they are
+-- about and sent by the mail worker
+CREATE TABLE IF NOT EXISTS email_outbox (
+  id SERIAL PRIMARY KEY,
+  recipient VARCHAR(255) NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  body TEXT NOT NULL,
+  status VARCHAR(20) NOT NULL DEFAULT 'pending'
+    CHECK (status IN ('pending', 'sent', 'failed')),
+  attempts INT NOT NULL DEFAULT 0,
+  lastError TEXT,
+  nextAttemptAt TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+  sentAt TIMESTAMP,
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_outbox_next_attempt_at ON email_outbox (nextAttemptAt) WHERE status = 'pending';
@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user';
+
+-- only the admin and user roles existed before
+UPDATE users SET role = 'admin'
+WHERE id IN (SELECT ur.userId FROM user_roles ur JOIN roles r ON r.id = ur.roleId WHERE r.name = 'admin');
+
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,65 @@
+CREATE TABLE IF NOT EXISTS roles (
+  id SERIAL PRIMARY KEY,
+  name VARCHAR(50) NOT NULL UNIQUE,
+  description VARCHAR(255) NOT NULL DEFAULT '',
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- permissions are named resource:action, the code checks them by name
+CREATE TABLE IF NOT EXISTS permissions (
+  id SERIAL PRIMARY KEY,
+  name VARCHAR(50) NOT NULL UNIQUE,
+  description VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+  roleId INT NOT NULL,
+  permissionId INT NOT NULL,
+
+  PRIMARY KEY (roleId, permissionId),
+  FOREIGN KEY (roleId) REFERENCES roles(id) ON DELETE CASCADE,
+  FOREIGN KEY (permissionId) REFERENCES permissions(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+  userId INT NOT NULL,
+  roleId INT NOT NULL,
+  createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (userId, roleId),
+  FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (roleId) REFERENCES roles(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles (roleId);
+
+INSERT INTO permissions (name, description) VALUES
+  ('products:read', 'export the catalog and see inventory history and categories'),
+  ('products:write', 'create, update and archive products, their variants, images and categories, and import products'),
+  ('orders:read', 'see all orders and returns'),
+  ('orders:write', 'change order statuses and reject or receive returns'),
+  ('orders:refund', 'approve returns, which refunds the customer'),
+  ('promotions:read', 'see promotions'),
+  ('promotions:write', 'create, update and delete promotions'),
+  ('users:read', 'see any user'),
+  ('users:manage', 'assign and remove roles')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles (name, description) VALUES
+  ('admin', 'full access'),
+  ('user', 'a customer'),
+  ('support', 'looks up orders and customers, can''t change the catalog')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (roleId, permissionId)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin'
+   OR (r.name = 'support' AND p.name IN ('products:read', 'orders:read', 'promotions:read', 'users:read'))
+ON CONFLICT DO NOTHING;
+
+-- users keep the role they had
+INSERT INTO user_roles (userId, roleId)
+SELECT u.id, r.id FROM users u JOIN roles r ON r.name = u.role
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE users DROP COLUMN IF EXISTS role;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_refunds_return_id;
+
+ALTER TABLE refunds DROP COLUMN IF EXISTS completedAt;
+ALTER TABLE refunds DROP COLUMN IF EXISTS lastError;
+ALTER TABLE refunds DROP COLUMN IF EXISTS status;
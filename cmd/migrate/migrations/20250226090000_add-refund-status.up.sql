@@ -0,0 +1,11 @@
+-- refunds made before this were given back when they were recorded
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'completed'
+  CHECK (status IN ('pending', 'completed'));
+ALTER TABLE refunds ALTER COLUMN status SET DEFAULT 'pending';
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS lastError TEXT NOT NULL DEFAULT '';
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS completedAt TIMESTAMP;
+
+UPDATE refunds SET completedAt = createdAt WHERE completedAt IS NULL;
+
+-- a return is refunded at most once
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refunds_return_id ON refunds (returnId);
@@ -0,0 +1,10 @@
+-- the status column was free text, bring existing rows in line first
+UPDATE orders SET status = LOWER(TRIM(status)) WHERE status <> LOWER(TRIM(status));
+UPDATE orders SET status = 'cancelled' WHERE status = 'canceled';
+UPDATE orders SET status = 'delivered' WHERE status IN ('complete', 'completed');
+-- anything else is left for an admin to move on from processing
+UPDATE orders SET status = 'processing'
+WHERE status NOT IN ('pending', 'paid', 'processing', 'shipped', 'delivered', 'cancelled', 'refunded', 'partially_refunded');
+
+-- the constraint was added NOT VALID, check the rows written before it too
+ALTER TABLE orders VALIDATE CONSTRAINT orders_status_check;
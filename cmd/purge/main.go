@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/duziem/ecommerce_proj/configs"
+	"github.com/duziem/ecommerce_proj/db"
+	"github.com/duziem/ecommerce_proj/services/product"
+	_ "github.com/lib/pq"
+)
+
+// Deletes products that were archived long enough ago and were never
+// ordered. Ordered products stay archived for the order history.
+func main() {
+	olderThan := flag.Duration("older-than", 30*24*time.Hour, "only purge products archived at least this long ago")
+	flag.Parse()
+
+	cfg := db.PostgresConfig{
+		Host:     configs.Envs.DBHost,
+		Port:     configs.Envs.DBPort,
+		User:     configs.Envs.DBUser,
+		Password: configs.Envs.DBPassword,
+		DbName:   configs.Envs.DbName,
+		SSLMode:  "disable",
+	}
+
+	db, err := db.NewPostgresStorage(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	purged, err := product.NewStore(db).PurgeProducts(time.Now().Add(-*olderThan))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("purged %d archived products", purged)
+}
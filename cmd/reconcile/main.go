@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/duziem/ecommerce_proj/configs"
+	"github.com/duziem/ecommerce_proj/db"
+	"github.com/duziem/ecommerce_proj/services/product"
+	_ "github.com/lib/pq"
+)
+
+// Checks that the stock of every variant equals the sum of its inventory
+// movements, exiting with status 1 when one doesn't.
+func main() {
+	cfg := db.PostgresConfig{
+		Host:     configs.Envs.DBHost,
+		Port:     configs.Envs.DBPort,
+		User:     configs.Envs.DBUser,
+		Password: configs.Envs.DBPassword,
+		DbName:   configs.Envs.DbName,
+		SSLMode:  "disable",
+	}
+
+	db, err := db.NewPostgresStorage(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	discrepancies, err := product.NewStore(db).GetInventoryDiscrepancies()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(discrepancies) == 0 {
+		log.Println("inventory reconciled: every variant's stock matches its movements")
+		return
+	}
+
+	for _, d := range discrepancies {
+		log.Printf("product %d variant %d (%s): stock is %d but movements add up to %d",
+			d.ProductID, d.VariantID, d.SKU, d.Quantity, d.LedgerQuantity)
+	}
+
+	log.Printf("%d variants don't match their movements", len(discrepancies))
+	os.Exit(1)
+}
@@ -8,15 +8,43 @@ import (
 )
 
 type Config struct {
-	PublicHost             string
-	Port                   string
-	DBHost                 string
-	DBPort                 int
-	DBUser                 string
-	DBPassword             string
-	DbName                 string
-	JWTSecret              string
-	JWTExpirationInSeconds int64
+	PublicHost                 string
+	Port                       string
+	DBHost                     string
+	DBPort                     int
+	DBUser                     string
+	DBPassword                 string
+	DbName                     string
+	JWTSecret                  string
+	JWTExpirationInSeconds     int64
+	RefreshTokenTTLInSeconds   int64
+	JWTIssuer                  string
+	JWTAudience                string
+	JWTLeewayInSeconds         int64
+	JWTSigningKeyFile          string
+	JWTVerificationKeyFiles    string
+	JWTHS256Fallback           bool
+	AppURL                     string
+	PasswordResetTTLInSeconds  int64
+	EmailVerifyTTLInSeconds    int64
+	MailTransport              string
+	MailDir                    string
+	MailFrom                   string
+	MailWorkerInSeconds        int64
+	SMTPHost                   string
+	SMTPPort                   int
+	SMTPUsername               string
+	SMTPPassword               string
+	Currency                   string
+	IdempotencyKeyTTLInSeconds int64
+	ShippingFeeInMinorUnits    int64
+	PaymentProvider            string
+	MockPaymentWebhookSecret   string
+	ReservationTTLInSeconds    int64
+	ReservationSweepInSeconds  int64
+	MediaDir                   string
+	MediaBaseURL               string
+	MaxImageSizeInBytes        int64
 }
 
 var Envs = initConfig()
@@ -25,15 +53,43 @@ func initConfig() Config {
 	godotenv.Load()
 
 	return Config{
-		PublicHost:             getEnv("PUBLIC_HOST", "http://localhost"),
-		Port:                   getEnv("PORT", "8080"),
-		DBUser:                 getEnv("DB_USER", "postgres"),
-		DBPassword:             getEnv("DB_PASSWORD", ""),
-		DBHost:                 getEnv("DB_HOST", "localhost"),
-		DBPort:                 getEnvAsInt("DB_PORT", 5432),
-		DbName:                 getEnv("DB_NAME", "ecommerce_db"),
-		JWTSecret:              getEnv("JWT_SECRET", "its-called-a-secret-for-a-reason"),
-		JWTExpirationInSeconds: getEnvAsInt64("JWT_EXPIRATION_IN_SECONDS", 3600*24*7),
+		PublicHost:                 getEnv("PUBLIC_HOST", "http://localhost"),
+		Port:                       getEnv("PORT", "8080"),
+		DBUser:                     getEnv("DB_USER", "postgres"),
+		DBPassword:                 getEnv("DB_PASSWORD", ""),
+		DBHost:                     getEnv("DB_HOST", "localhost"),
+		DBPort:                     getEnvAsInt("DB_PORT", 5432),
+		DbName:                     getEnv("DB_NAME", "ecommerce_db"),
+		JWTSecret:                  getEnv("JWT_SECRET", "its-called-a-secret-for-a-reason"),
+		JWTExpirationInSeconds:     getEnvAsInt64("JWT_EXPIRATION_IN_SECONDS", 60*15),
+		RefreshTokenTTLInSeconds:   getEnvAsInt64("REFRESH_TOKEN_TTL_IN_SECONDS", 3600*24*30),
+		JWTIssuer:                  getEnv("JWT_ISSUER", "ecommerce-api"),
+		JWTAudience:                getEnv("JWT_AUDIENCE", "ecommerce-api"),
+		JWTLeewayInSeconds:         getEnvAsInt64("JWT_LEEWAY_IN_SECONDS", 30),
+		JWTSigningKeyFile:          getEnv("JWT_SIGNING_KEY_FILE", ""),
+		JWTVerificationKeyFiles:    getEnv("JWT_VERIFICATION_KEY_FILES", ""),
+		JWTHS256Fallback:           getEnvAsBool("JWT_HS256_FALLBACK", false),
+		AppURL:                     getEnv("APP_URL", "http://localhost:8080"),
+		PasswordResetTTLInSeconds:  getEnvAsInt64("PASSWORD_RESET_TTL_IN_SECONDS", 3600),
+		EmailVerifyTTLInSeconds:    getEnvAsInt64("EMAIL_VERIFY_TTL_IN_SECONDS", 3600*48),
+		MailTransport:              getEnv("MAIL_TRANSPORT", "log"),
+		MailDir:                    getEnv("MAIL_DIR", ""),
+		MailFrom:                   getEnv("MAIL_FROM", "no-reply@localhost"),
+		MailWorkerInSeconds:        getEnvAsInt64("MAIL_WORKER_IN_SECONDS", 10),
+		SMTPHost:                   getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:                   getEnvAsInt("SMTP_PORT", 587),
+		SMTPUsername:               getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:               getEnv("SMTP_PASSWORD", ""),
+		Currency:                   getEnv("CURRENCY", "USD"),
+		IdempotencyKeyTTLInSeconds: getEnvAsInt64("IDEMPOTENCY_KEY_TTL_IN_SECONDS", 3600*24),
+		ShippingFeeInMinorUnits:    getEnvAsInt64("SHIPPING_FEE_IN_MINOR_UNITS", 0),
+		PaymentProvider:            getEnv("PAYMENT_PROVIDER", "mock"),
+		MockPaymentWebhookSecret:   getEnv("MOCK_PAYMENT_WEBHOOK_SECRET", "mock-webhook-secret"),
+		ReservationTTLInSeconds:    getEnvAsInt64("RESERVATION_TTL_IN_SECONDS", 60*15),
+		ReservationSweepInSeconds:  getEnvAsInt64("RESERVATION_SWEEP_IN_SECONDS", 60),
+		MediaDir:                   getEnv("MEDIA_DIR", "media"),
+		MediaBaseURL:               getEnv("MEDIA_BASE_URL", "/media"),
+		MaxImageSizeInBytes:        getEnvAsInt64("MAX_IMAGE_SIZE_IN_BYTES", 10<<20),
 	}
 }
 
@@ -58,6 +114,19 @@ func getEnvAsInt64(key string, fallback int64) int64 {
 
 	return fallback
 }
+func getEnvAsBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fallback
+		}
+
+		return b
+	}
+
+	return fallback
+}
+
 func getEnvAsInt(key string, fallback int) int {
 	if value, ok := os.LookupEnv(key); ok {
 		i, err := strconv.Atoi(value)
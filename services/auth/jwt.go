@@ -2,9 +2,12 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
@@ -16,45 +19,24 @@ import (
 
 type contextKey string
 
-const UserKey contextKey = "userID"
+const (
+	UserKey    contextKey = "userID"
+	SessionKey contextKey = "sessionID"
+)
 
 func WithJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tokenString := utils.GetTokenFromRequest(r)
-
-		token, err := validateJWT(tokenString)
-		if err != nil {
-			log.Printf("failed to validate token: %v", err)
-			permissionDenied(w)
-			return
-		}
-
-		if !token.Valid {
-			log.Println("invalid token")
-			permissionDenied(w)
-			return
-		}
-
-		claims := token.Claims.(jwt.MapClaims)
-		str := claims["userID"].(string)
-
-		userID, err := strconv.Atoi(str)
-		if err != nil {
-			log.Printf("failed to convert userID to int: %v", err)
-			permissionDenied(w)
-			return
-		}
-
-		u, err := store.GetUserByID(userID)
+		u, sessionID, err := authenticate(r, store)
 		if err != nil {
-			log.Printf("failed to get user by id: %v", err)
+			log.Println(err)
 			permissionDenied(w)
 			return
 		}
 
-		// Add the user to the context
+		// Add the user and their session to the context
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, UserKey, u.ID)
+		ctx = context.WithValue(ctx, SessionKey, sessionID)
 		r = r.WithContext(ctx)
 
 		// Call the function if the token is valid
@@ -62,53 +44,126 @@ func WithJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.Handl
 	}
 }
 
-func WithAdminRole(handlerFunc http.HandlerFunc, store types.UserStore) http.HandlerFunc {
+// WithOptionalJWTAuth adds the user to the context when the request carries a
+// valid token, and otherwise lets it through as anonymous.
+func WithOptionalJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if utils.GetTokenFromRequest(r) != "" {
+			if u, sessionID, err := authenticate(r, store); err == nil {
+				ctx := context.WithValue(r.Context(), UserKey, u.ID)
+				r = r.WithContext(context.WithValue(ctx, SessionKey, sessionID))
+			}
+		}
+
+		handlerFunc(w, r)
+	}
+}
+
+// authenticate returns the user of the request's token and the session it
+// was issued for, which must not have been revoked.
+func authenticate(r *http.Request, store types.UserStore) (*types.User, int, error) {
+	tokenString := utils.GetTokenFromRequest(r)
+
+	claims, err := validateJWT(tokenString)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to validate token: %v", err)
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to convert subject to a user ID: %v", err)
+	}
+
+	session, err := store.GetActiveSession(claims.SessionID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get session %d: %v", claims.SessionID, err)
+	}
+	if session.UserID != userID {
+		return nil, 0, fmt.Errorf("session %d doesn't belong to user %d", claims.SessionID, userID)
+	}
+
+	u, err := store.GetUserByID(userID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get user by id: %v", err)
+	}
+
+	return u, session.ID, nil
+}
+
+// RequirePermission lets the request through when the user has the
+// permission through one of their roles.
+func RequirePermission(permission string, handlerFunc http.HandlerFunc, store types.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get user ID from context
 		userID := GetUserIDFromContext(r.Context())
 
-		// Fetch user from store
-		u, err := store.GetUserByID(userID)
+		// Fetch the user's permissions from store
+		permissions, err := store.GetUserPermissions(userID)
 		if err != nil {
+			log.Printf("failed to get the permissions of user %d: %v", userID, err)
 			permissionDenied(w)
 			return
 		}
 
-		// Check if the user has admin privileges
-		if u.Role != "admin" {
+		if !slices.Contains(permissions, permission) {
 			permissionDenied(w)
 			return
 		}
 
-		// Call the next handler if the user is an admin
+		// Call the next handler if the user has the permission
 		handlerFunc(w, r)
 	}
 }
 
-func CreateJWT(secret []byte, userID int) (string, error) {
-	expiration := time.Second * time.Duration(configs.Envs.JWTExpirationInSeconds)
+// Claims are the claims of an access token. The user is the subject.
+type Claims struct {
+	SessionID int `json:"sid"`
+	jwt.RegisteredClaims
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID":    strconv.Itoa(int(userID)),
-		"expiresAt": time.Now().Add(expiration).Unix(),
-	})
+// CreateJWT issues a short-lived access token for the user's session, a
+// refresh token is needed to get a new one.
+func CreateJWT(userID, sessionID int) (string, error) {
+	expiration := time.Second * time.Duration(configs.Envs.JWTExpirationInSeconds)
 
-	tokenString, err := token.SignedString(secret)
-	if err != nil {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
 		return "", err
 	}
 
-	return tokenString, err
+	now := time.Now()
+	return keys.sign(Claims{
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(id),
+			Subject:   strconv.Itoa(userID),
+			Issuer:    configs.Envs.JWTIssuer,
+			Audience:  jwt.ClaimStrings{configs.Envs.JWTAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiration)),
+		},
+	})
 }
 
-func validateJWT(tokenString string) (*jwt.Token, error) {
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
+// validateJWT checks the signature against the key named by the token and the
+// time, issuer and audience claims, allowing JWT_LEEWAY_IN_SECONDS of clock skew.
+func validateJWT(tokenString string) (*Claims, error) {
+	claims := new(Claims)
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, keys.verificationKey,
+		jwt.WithValidMethods(keys.methods()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(configs.Envs.JWTIssuer),
+		jwt.WithAudience(configs.Envs.JWTAudience),
+		jwt.WithLeeway(time.Second*time.Duration(configs.Envs.JWTLeewayInSeconds)),
+	)
+	if err != nil {
+		return nil, err
+	}
 
-		return []byte(configs.Envs.JWTSecret), nil
-	})
+	return claims, nil
 }
 
 func permissionDenied(w http.ResponseWriter) {
@@ -123,3 +178,12 @@ func GetUserIDFromContext(ctx context.Context) int {
 
 	return userID
 }
+
+func GetSessionIDFromContext(ctx context.Context) int {
+	sessionID, ok := ctx.Value(SessionKey).(int)
+	if !ok {
+		return -1
+	}
+
+	return sessionID
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/duziem/ecommerce_proj/configs"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testLeeway = 30 * time.Second
+
+func setupJWT(t *testing.T) {
+	t.Helper()
+
+	envs, previous := configs.Envs, keys
+	t.Cleanup(func() {
+		configs.Envs = envs
+		SetKeys(previous)
+	})
+
+	configs.Envs.JWTIssuer = "test-issuer"
+	configs.Envs.JWTAudience = "test-audience"
+	configs.Envs.JWTLeewayInSeconds = int64(testLeeway / time.Second)
+	configs.Envs.JWTExpirationInSeconds = 900
+	SetKeys(secretKeys("test-secret"))
+}
+
+// signClaims signs a token valid for a minute from now, after edit has
+// changed its claims.
+func signClaims(t *testing.T, edit func(*jwt.RegisteredClaims)) string {
+	t.Helper()
+
+	now := time.Now()
+	claims := Claims{
+		SessionID: 7,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "42",
+			Issuer:    "test-issuer",
+			Audience:  jwt.ClaimStrings{"test-audience"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+	if edit != nil {
+		edit(&claims.RegisteredClaims)
+	}
+
+	token, err := keys.sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestValidateJWT(t *testing.T) {
+	setupJWT(t)
+
+	token, err := CreateJWT(42, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := validateJWT(token)
+	if err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if claims.Subject != "42" || claims.SessionID != 7 {
+		t.Errorf("got subject %q and session %d, want 42 and 7", claims.Subject, claims.SessionID)
+	}
+}
+
+func TestValidateJWTRejectsInvalidClaims(t *testing.T) {
+	setupJWT(t)
+
+	tests := []struct {
+		name string
+		edit func(*jwt.RegisteredClaims)
+		want error
+	}{
+		{
+			name: "expired",
+			edit: func(c *jwt.RegisteredClaims) {
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+			},
+			want: jwt.ErrTokenExpired,
+		},
+		{
+			name: "no expiry",
+			edit: func(c *jwt.RegisteredClaims) {
+				c.ExpiresAt = nil
+			},
+			want: jwt.ErrTokenRequiredClaimMissing,
+		},
+		{
+			name: "not yet valid",
+			edit: func(c *jwt.RegisteredClaims) {
+				c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour))
+			},
+			want: jwt.ErrTokenNotValidYet,
+		},
+		{
+			name: "issued in the future",
+			edit: func(c *jwt.RegisteredClaims) {
+				c.IssuedAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+			},
+			want: jwt.ErrTokenUsedBeforeIssued,
+		},
+		{
+			name: "wrong audience",
+			edit: func(c *jwt.RegisteredClaims) {
+				c.Audience = jwt.ClaimStrings{"another-api"}
+			},
+			want: jwt.ErrTokenInvalidAudience,
+		},
+		{
+			name: "no audience",
+			edit: func(c *jwt.RegisteredClaims) {
+				c.Audience = nil
+			},
+			want: jwt.ErrTokenRequiredClaimMissing,
+		},
+		{
+			name: "wrong issuer",
+			edit: func(c *jwt.RegisteredClaims) {
+				c.Issuer = "another-issuer"
+			},
+			want: jwt.ErrTokenInvalidIssuer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateJWT(signClaims(t, tt.edit))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// The claims are in whole seconds, so the tokens are a couple of seconds to
+// either side of the leeway.
+func TestValidateJWTLeeway(t *testing.T) {
+	setupJWT(t)
+
+	const margin = 2 * time.Second
+
+	tests := []struct {
+		name string
+		edit func(*jwt.RegisteredClaims)
+		want error
+	}{
+		{
+			name: "expired within the leeway",
+			edit: func(c *jwt.RegisteredClaims) {
+				c.IssuedAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+				c.NotBefore = c.IssuedAt
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-testLeeway + margin))
+			},
+		},
+		{
+			name: "expired beyond the leeway",
+			edit: func(c *jwt.RegisteredClaims) {
+				c.IssuedAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+				c.NotBefore = c.IssuedAt
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-testLeeway - margin))
+			},
+			want: jwt.ErrTokenExpired,
+		},
+		{
+			name: "not yet valid within the leeway",
+			edit: func(c *jwt.RegisteredClaims) {
+				c.NotBefore = jwt.NewNumericDate(time.Now().Add(testLeeway - margin))
+			},
+		},
+		{
+			name: "not yet valid beyond the leeway",
+			edit: func(c *jwt.RegisteredClaims) {
+				c.NotBefore = jwt.NewNumericDate(time.Now().Add(testLeeway + margin))
+			},
+			want: jwt.ErrTokenNotValidYet,
+		},
+		{
+			name: "issued ahead within the leeway",
+			edit: func(c *jwt.RegisteredClaims) {
+				c.IssuedAt = jwt.NewNumericDate(time.Now().Add(testLeeway - margin))
+			},
+		},
+		{
+			name: "issued ahead beyond the leeway",
+			edit: func(c *jwt.RegisteredClaims) {
+				c.IssuedAt = jwt.NewNumericDate(time.Now().Add(testLeeway + margin))
+			},
+			want: jwt.ErrTokenUsedBeforeIssued,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateJWT(signClaims(t, tt.edit))
+			if tt.want == nil && err != nil {
+				t.Errorf("token rejected: %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/duziem/ecommerce_proj/configs"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Keys signs access tokens with one key and verifies them with any of its
+// public keys, so a new signing key can be rolled out while tokens signed
+// with the previous one are still around. Tokens name their key in the kid
+// header. HS256 tokens are signed and verified with JWT_SECRET.
+type Keys struct {
+	signing   *key
+	verifying map[string]*key
+	// secret verifies HS256 tokens, nil when they aren't accepted
+	secret []byte
+}
+
+type key struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+}
+
+// keys are used by CreateJWT and validateJWT, HS256 with JWT_SECRET until
+// SetKeys is called
+var keys = secretKeys(configs.Envs.JWTSecret)
+
+func SetKeys(k *Keys) {
+	keys = k
+}
+
+func secretKeys(secret string) *Keys {
+	return &Keys{
+		signing:   &key{method: jwt.SigningMethodHS256, private: []byte(secret)},
+		verifying: map[string]*key{},
+		secret:    []byte(secret),
+	}
+}
+
+// LoadKeys loads the signing key from JWT_SIGNING_KEY_FILE, signing with
+// HS256 when there is none, and the previous public keys that are still
+// accepted from JWT_VERIFICATION_KEY_FILES.
+func LoadKeys(cfg configs.Config) (*Keys, error) {
+	if cfg.JWTSigningKeyFile == "" {
+		return secretKeys(cfg.JWTSecret), nil
+	}
+
+	data, err := os.ReadFile(cfg.JWTSigningKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	signing, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key %s: %w", cfg.JWTSigningKeyFile, err)
+	}
+
+	k := &Keys{signing: signing, verifying: map[string]*key{signing.id: signing}}
+	if cfg.JWTHS256Fallback {
+		k.secret = []byte(cfg.JWTSecret)
+	}
+
+	for _, name := range strings.Split(cfg.JWTVerificationKeyFiles, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read verification key: %w", err)
+		}
+
+		verifying, err := parsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid verification key %s: %w", name, err)
+		}
+		k.verifying[verifying.id] = verifying
+	}
+
+	return k, nil
+}
+
+func parsePrivateKey(data []byte) (*key, error) {
+	if private, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return newKey(jwt.SigningMethodRS256, private, &private.PublicKey)
+	}
+
+	private, err := jwt.ParseEdPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("expected an RSA or Ed25519 private key in PEM format")
+	}
+
+	return newKey(jwt.SigningMethodEdDSA, private, private.(ed25519.PrivateKey).Public())
+}
+
+func parsePublicKey(data []byte) (*key, error) {
+	if public, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return newKey(jwt.SigningMethodRS256, nil, public)
+	}
+
+	public, err := jwt.ParseEdPublicKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("expected an RSA or Ed25519 public key in PEM format")
+	}
+
+	return newKey(jwt.SigningMethodEdDSA, nil, public)
+}
+
+// newKey names the key after a hash of its public key, so the kid of a key
+// stays the same when it moves from signing to verification only.
+func newKey(method jwt.SigningMethod, private crypto.PrivateKey, public crypto.PublicKey) (*key, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(der)
+	id := base64.RawURLEncoding.EncodeToString(sum[:12])
+
+	return &key{id: id, method: method, private: private, public: public}, nil
+}
+
+// sign signs the claims with the signing key.
+func (k *Keys) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	if k.signing.id != "" {
+		token.Header["kid"] = k.signing.id
+	}
+
+	return token.SignedString(k.signing.private)
+}
+
+// methods are the algorithms of the tokens that are accepted.
+func (k *Keys) methods() []string {
+	methods := []string{}
+	if k.secret != nil {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+
+	for _, v := range k.verifying {
+		methods = append(methods, v.method.Alg())
+	}
+
+	return methods
+}
+
+// verificationKey finds the key a token was signed with.
+func (k *Keys) verificationKey(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+		if k.secret == nil {
+			return nil, fmt.Errorf("HS256 tokens are not accepted")
+		}
+
+		return k.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	v, ok := k.verifying[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if v.method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("signing key %q is not a %s key", kid, token.Method.Alg())
+	}
+
+	return v.public, nil
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS lists the public keys tokens can be verified with. The HS256 secret
+// is never published.
+func (k *Keys) JWKS() []JWK {
+	jwks := []JWK{}
+	for _, v := range k.verifying {
+		jwk := JWK{KeyID: v.id, Use: "sig", Algorithm: v.method.Alg()}
+
+		switch public := v.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+
+		jwks = append(jwks, jwk)
+	}
+
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].KeyID < jwks[j].KeyID })
+
+	return jwks
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/duziem/ecommerce_proj/utils"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	keys *Keys
+}
+
+func NewHandler(keys *Keys) *Handler {
+	return &Handler{keys: keys}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// the public keys other services can verify access tokens with
+	router.HandleFunc("/.well-known/jwks.json", h.handleJWKS).Methods(http.MethodGet)
+}
+
+func (h *Handler) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	// keys are rotated rarely, but a cached set shouldn't outlive a rotation by long
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"keys": h.keys.JWKS()})
+}
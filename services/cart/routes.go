@@ -1,10 +1,18 @@
 package cart
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/duziem/ecommerce_proj/configs"
 	"github.com/duziem/ecommerce_proj/services/auth"
+	"github.com/duziem/ecommerce_proj/services/idempotency"
+	"github.com/duziem/ecommerce_proj/services/payments"
+	"github.com/duziem/ecommerce_proj/services/promotion"
 	"github.com/duziem/ecommerce_proj/types"
 	"github.com/duziem/ecommerce_proj/utils"
 	"github.com/go-playground/validator/v10"
@@ -12,25 +20,54 @@ import (
 )
 
 type Handler struct {
-	store      types.ProductStore
-	orderStore types.OrderStore
-	userStore  types.UserStore
+	store            types.ProductStore
+	cartStore        types.CartStore
+	orderStore       types.OrderStore
+	userStore        types.UserStore
+	idempotencyStore types.IdempotencyStore
+	promotionStore   types.PromotionStore
+	payments         *payments.Service
 }
 
 func NewHandler(
 	store types.ProductStore,
+	cartStore types.CartStore,
 	orderStore types.OrderStore,
 	userStore types.UserStore,
+	idempotencyStore types.IdempotencyStore,
+	promotionStore types.PromotionStore,
+	payments *payments.Service,
 ) *Handler {
 	return &Handler{
-		store:      store,
-		orderStore: orderStore,
-		userStore:  userStore,
+		store:            store,
+		cartStore:        cartStore,
+		orderStore:       orderStore,
+		userStore:        userStore,
+		idempotencyStore: idempotencyStore,
+		promotionStore:   promotionStore,
+		payments:         payments,
 	}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/cart/checkout", auth.WithJWTAuth(h.handleCheckout, h.userStore)).Methods(http.MethodPost)
+	// the cart routes also serve anonymous visitors, whose cart is named by
+	// the token in the cart_token cookie or X-Cart-Token header
+
+	// get the stored cart, priced against the current catalog
+	router.HandleFunc("/cart", auth.WithOptionalJWTAuth(h.handleGetCart, h.userStore)).Methods(http.MethodGet)
+	// replace the items in the cart
+	router.HandleFunc("/cart", auth.WithOptionalJWTAuth(h.handleUpdateCart, h.userStore)).Methods(http.MethodPut)
+	// empty the cart
+	router.HandleFunc("/cart", auth.WithOptionalJWTAuth(h.handleClearCart, h.userStore)).Methods(http.MethodDelete)
+	// add a product or change its quantity, a quantity of 0 removes it
+	router.HandleFunc("/cart/items/{productID}", auth.WithOptionalJWTAuth(h.handleSetCartItem, h.userStore)).Methods(http.MethodPut)
+	// remove a product, or only one of its variants with ?variantID=
+	router.HandleFunc("/cart/items/{productID}", auth.WithOptionalJWTAuth(h.handleRemoveCartItem, h.userStore)).Methods(http.MethodDelete)
+
+	// check out the items in the payload, or the stored cart when there are none,
+	// with an optional promotionCode, and pay for the order with paymentMethod
+	// retries sent with the same Idempotency-Key header get the original response back
+	router.HandleFunc("/cart/checkout", auth.WithJWTAuth(idempotency.WithIdempotencyKey(h.handleCheckout, h.idempotencyStore), h.userStore)).Methods(http.MethodPost)
 }
 
 func (h *Handler) handleCheckout(w http.ResponseWriter, r *http.Request) {
@@ -48,6 +85,19 @@ func (h *Handler) handleCheckout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Fall back to the stored cart when no items were sent
+	var storedCart *types.Cart
+	if len(cart.Items) == 0 {
+		var err error
+		storedCart, err = h.cartStore.GetOrCreateUserCart(userID)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		cart.Items = toCheckoutItems(storedCart.Items)
+	}
+
 	productIDs, err := getCartItemsIDs(cart.Items)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
@@ -75,6 +125,12 @@ func (h *Handler) handleCheckout(w http.ResponseWriter, r *http.Request) {
 		productsMap[product.ID] = product
 	}
 
+	// Resolve the variant of each item, products with a single variant may omit it
+	if err := resolveCartItemVariants(cart.Items, productsMap); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
 	// Validate stock availability
 	if err := checkIfCartIsInStock(cart.Items, productsMap); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
@@ -82,41 +138,408 @@ func (h *Handler) handleCheckout(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Calculate total price
-	totalPrice := calculateTotalPrice(cart.Items, productsMap)
+	subtotal, err := calculateTotalPrice(cart.Items, productsMap)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Apply the promotion code, its row stays locked until the order is
+	// committed so its usage limits can't be exceeded
+	shipping := types.NewMoney(configs.Envs.ShippingFeeInMinorUnits, "")
+	discount := &types.PromotionDiscount{Discount: types.NewMoney(0, ""), ShippingDiscount: types.NewMoney(0, "")}
+	var promo *types.Promotion
+	if cart.PromotionCode != "" {
+		promo, err = h.promotionStore.GetPromotionByCodeWithLock(tx, cart.PromotionCode)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid promotion code"))
+			return
+		}
+
+		redemptions, err := h.promotionStore.CountUserRedemptions(tx, promo.ID, userID)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if err := promotion.CheckUsageLimits(promo, redemptions); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		discount, err = promotion.Apply(promo, cart.Items, productsMap, shipping, time.Now())
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
 
-	// Update product quantities
-	if err := h.store.UpdateProductQuantities(tx, cart.Items); err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to update product quantities: %v", err))
+	totalPrice, err := calculateOrderTotal(subtotal, shipping, discount)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
+	shippingTotal, err := shipping.Sub(discount.ShippingDiscount)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	order := types.Order{
+		UserID:        userID,
+		Total:         totalPrice,
+		Discount:      discount.Discount,
+		ShippingTotal: shippingTotal,
+		Status:        types.OrderStatusPending,
+		Address:       cart.Address, // Use address from the payload
+	}
+	if promo != nil {
+		order.PromotionID = &promo.ID
+	}
+
 	// Create order
-	orderID, err := h.orderStore.CreateOrder(tx, types.Order{
-		UserID:  userID,
-		Total:   totalPrice,
-		Status:  "pending",
-		Address: cart.Address, // Use address from the payload
-	})
+	orderID, err := h.orderStore.CreateOrder(tx, order)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to create order: %v", err))
 		return
 	}
 
 	// Create order items
-	if err := h.orderStore.CreateOrderItems(tx, orderID, cart.Items, productsMap); err != nil {
+	if err := h.orderStore.CreateOrderItems(tx, orderID, cart.Items, productsMap, discount.ItemDiscounts); err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to create order items: %v", err))
 		return
 	}
 
+	// Hold the stock while the order is being paid for, it is taken off the
+	// shelf once the payment is captured and released if it never is
+	expiresAt := time.Now().Add(time.Duration(configs.Envs.ReservationTTLInSeconds) * time.Second)
+	if err := h.store.ReserveStock(tx, orderID, cart.Items, expiresAt); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Count the redemption in the same transaction as the order
+	if promo != nil {
+		redeemed, err := discount.Discount.Add(discount.ShippingDiscount)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if err := h.promotionStore.RedeemPromotion(tx, promo.ID, userID, orderID, redeemed); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	// The stored cart has been turned into an order
+	if storedCart != nil {
+		if err := h.cartStore.ClearCartTx(tx, storedCart.ID); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
 	// Commit the transaction
 	if err := tx.Commit(); err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to commit transaction: %v", err))
 		return
 	}
 
-	// Respond with success
-	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{
+	// Take the payment, the order is only marked paid once it is captured
+	// and is cancelled when no payment was taken
+	order.ID = orderID
+	err = h.payments.PayOrder(r.Context(), &order, cart.PaymentMethod)
+	if errors.Is(err, payments.ErrPaymentDeclined) || errors.Is(err, payments.ErrPaymentFailed) {
+		utils.WriteError(w, http.StatusPaymentRequired, err)
+		return
+	}
+	if errors.Is(err, payments.ErrOrderCancelled) {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	// The order is committed and the customer may have paid for it, so
+	// other errors report the order as it is stored rather than a server
+	// error that would let the request be retried
+	code, status := http.StatusOK, types.OrderStatusPaid
+	if err != nil {
+		log.Printf("failed to complete the payment of order %d: %v", orderID, err)
+
+		code, status = http.StatusAccepted, types.OrderStatusPending
+		if stored, err := h.orderStore.GetOrderByID(orderID); err == nil {
+			status = stored.Status
+		}
+	}
+
+	utils.WriteJSON(w, code, map[string]interface{}{
 		"total_price": totalPrice,
+		"subtotal":    subtotal,
+		"discount":    discount.Discount,
+		"shipping":    shippingTotal,
 		"order_id":    orderID,
+		"status":      status,
 	})
 }
+
+func (h *Handler) handleGetCart(w http.ResponseWriter, r *http.Request) {
+	cart, err := h.getCart(w, r, false)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeCart(w, cart)
+}
+
+func (h *Handler) handleUpdateCart(w http.ResponseWriter, r *http.Request) {
+	var payload types.UpdateCartPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	productIDs, err := getCartItemsIDs(payload.Items)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var items []types.CartItem
+	if len(productIDs) > 0 {
+		productsMap, err := h.getProductsMap(productIDs)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		items, err = toCartItems(payload.Items, productsMap)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	cart, err := h.getCart(w, r, true)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.cartStore.ReplaceCartItems(cart.ID, items); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeCartByID(w, cart.ID)
+}
+
+func (h *Handler) handleClearCart(w http.ResponseWriter, r *http.Request) {
+	cart, err := h.getCart(w, r, false)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if cart.ID == 0 {
+		h.writeCart(w, cart)
+		return
+	}
+
+	if err := h.cartStore.ClearCart(cart.ID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeCartByID(w, cart.ID)
+}
+
+func (h *Handler) handleSetCartItem(w http.ResponseWriter, r *http.Request) {
+	var payload types.SetCartItemPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	productID, err := getProductID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cart, err := h.getCart(w, r, true)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if payload.Quantity == 0 {
+		if err := h.cartStore.RemoveCartItems(cart.ID, productID, payload.VariantID); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		h.writeCartByID(w, cart.ID)
+		return
+	}
+
+	productsMap, err := h.getProductsMap([]int{productID})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	items, err := toCartItems([]types.CartCheckoutItem{{
+		ProductID: productID,
+		VariantID: payload.VariantID,
+		Quantity:  payload.Quantity,
+	}}, productsMap)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.cartStore.SetCartItem(cart.ID, items[0]); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeCartByID(w, cart.ID)
+}
+
+func (h *Handler) handleRemoveCartItem(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	variantID := 0
+	if str := r.URL.Query().Get("variantID"); str != "" {
+		variantID, err = strconv.Atoi(str)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid variant ID"))
+			return
+		}
+	}
+
+	cart, err := h.getCart(w, r, false)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if cart.ID == 0 {
+		h.writeCart(w, cart)
+		return
+	}
+
+	if err := h.cartStore.RemoveCartItems(cart.ID, productID, variantID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeCartByID(w, cart.ID)
+}
+
+// getCart returns the cart of the logged in user, or else the guest cart named
+// by the request's cart token. When there is no cart one is created if create
+// is set and its token is sent back, otherwise an empty cart is returned.
+func (h *Handler) getCart(w http.ResponseWriter, r *http.Request, create bool) (*types.Cart, error) {
+	if userID := auth.GetUserIDFromContext(r.Context()); userID > 0 {
+		return h.cartStore.GetOrCreateUserCart(userID)
+	}
+
+	if token := utils.GetCartTokenFromRequest(r); token != "" {
+		cart, err := h.cartStore.GetGuestCart(auth.HashOpaqueToken(token))
+		if !errors.Is(err, ErrCartNotFound) {
+			return cart, err
+		}
+	}
+
+	if !create {
+		return &types.Cart{Items: []types.CartItem{}}, nil
+	}
+
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	cart, err := h.cartStore.CreateGuestCart(tokenHash)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.SetCartTokenCookie(w, token)
+	w.Header().Set(utils.CartTokenHeader, token)
+
+	return cart, nil
+}
+
+func (h *Handler) writeCartByID(w http.ResponseWriter, cartID int) {
+	cart, err := h.cartStore.GetCartByID(cartID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeCart(w, cart)
+}
+
+// writeCart responds with the cart priced against the current catalog.
+func (h *Handler) writeCart(w http.ResponseWriter, cart *types.Cart) {
+	productsMap := make(map[int]types.Product)
+	if len(cart.Items) > 0 {
+		var err error
+		productsMap, err = h.getProductsMap(getCartProductIDs(cart.Items))
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	view, err := buildCartView(cart, productsMap)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, view)
+}
+
+func (h *Handler) getProductsMap(productIDs []int) (map[int]types.Product, error) {
+	products, err := h.store.GetProductsByID(productIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch products: %v", err)
+	}
+
+	productsMap := make(map[int]types.Product)
+	for _, product := range products {
+		productsMap[product.ID] = product
+	}
+
+	return productsMap, nil
+}
+
+func getProductID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	str, ok := vars["productID"]
+	if !ok {
+		return 0, fmt.Errorf("missing product ID")
+	}
+
+	productID, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid product ID")
+	}
+
+	return productID, nil
+}
@@ -19,32 +19,207 @@ func getCartItemsIDs(items []types.CartCheckoutItem) ([]int, error) {
 	return productIds, nil
 }
 
+// resolveCartItemVariants fills in the variant of items that didn't name one
+// and makes sure every variant belongs to its item's product.
+func resolveCartItemVariants(cartItems []types.CartCheckoutItem, products map[int]types.Product) error {
+	for i, item := range cartItems {
+		product, ok := products[item.ProductID]
+		if !ok || product.DeletedAt != nil {
+			return fmt.Errorf("product %d is not available in the store, please refresh your cart", item.ProductID)
+		}
+
+		variant, ok := product.FindVariant(item.VariantID)
+		if !ok {
+			if item.VariantID == 0 {
+				return fmt.Errorf("product %s comes in several variants, please choose one", product.Name)
+			}
+			return fmt.Errorf("variant %d of product %s is not available, please refresh your cart", item.VariantID, product.Name)
+		}
+
+		cartItems[i].VariantID = variant.ID
+	}
+
+	return nil
+}
+
 func checkIfCartIsInStock(cartItems []types.CartCheckoutItem, products map[int]types.Product) error {
 	if len(cartItems) == 0 {
 		return fmt.Errorf("cart is empty")
 	}
 
+	// the same variant may appear on several lines
+	requested := make(map[int]int)
+	for _, item := range cartItems {
+		requested[item.VariantID] += item.Quantity
+	}
+
 	for _, item := range cartItems {
 		product, ok := products[item.ProductID]
-		if !ok {
+		if !ok || product.DeletedAt != nil {
 			return fmt.Errorf("product %d is not available in the store, please refresh your cart", item.ProductID)
 		}
 
-		if product.Quantity < item.Quantity {
-			return fmt.Errorf("product %s is not available in the quantity requested", product.Name)
+		variant, ok := product.FindVariant(item.VariantID)
+		if !ok {
+			return fmt.Errorf("variant %d of product %s is not available, please refresh your cart", item.VariantID, product.Name)
+		}
+
+		if variant.Available < requested[variant.ID] {
+			return fmt.Errorf("product %s (%s) is not available in the quantity requested", product.Name, variant.SKU)
 		}
 	}
 
 	return nil
 }
 
-func calculateTotalPrice(cartItems []types.CartCheckoutItem, products map[int]types.Product) float64 {
-	var total float64
+func calculateTotalPrice(cartItems []types.CartCheckoutItem, products map[int]types.Product) (types.Money, error) {
+	total := types.NewMoney(0, "")
 
 	for _, item := range cartItems {
-		product := products[item.ProductID]
-		total += product.Price * float64(item.Quantity)
+		variant, _ := products[item.ProductID].FindVariant(item.VariantID)
+
+		lineTotal, err := variant.Price.Mul(item.Quantity)
+		if err != nil {
+			return types.Money{}, err
+		}
+
+		total, err = total.Add(lineTotal)
+		if err != nil {
+			return types.Money{}, err
+		}
+	}
+
+	return total, nil
+}
+
+// calculateOrderTotal adds shipping to the subtotal and takes off the
+// promotion's discounts.
+func calculateOrderTotal(subtotal, shipping types.Money, discount *types.PromotionDiscount) (types.Money, error) {
+	total, err := subtotal.Add(shipping)
+	if err != nil {
+		return types.Money{}, err
+	}
+
+	if total, err = total.Sub(discount.Discount); err != nil {
+		return types.Money{}, err
+	}
+
+	return total.Sub(discount.ShippingDiscount)
+}
+
+// toCartItems resolves the variant and current price of each requested item,
+// merging lines that point at the same variant.
+func toCartItems(requested []types.CartCheckoutItem, products map[int]types.Product) ([]types.CartItem, error) {
+	if err := resolveCartItemVariants(requested, products); err != nil {
+		return nil, err
+	}
+
+	items := make([]types.CartItem, 0, len(requested))
+	positions := make(map[int]int)
+	for _, r := range requested {
+		if i, ok := positions[r.VariantID]; ok {
+			items[i].Quantity += r.Quantity
+			continue
+		}
+
+		variant, _ := products[r.ProductID].FindVariant(r.VariantID)
+		positions[r.VariantID] = len(items)
+		items = append(items, types.CartItem{
+			ProductID: r.ProductID,
+			VariantID: r.VariantID,
+			Quantity:  r.Quantity,
+			Price:     variant.Price,
+		})
+	}
+
+	return items, nil
+}
+
+func toCheckoutItems(items []types.CartItem) []types.CartCheckoutItem {
+	checkoutItems := make([]types.CartCheckoutItem, len(items))
+	for i, item := range items {
+		checkoutItems[i] = types.CartCheckoutItem{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Quantity:  item.Quantity,
+		}
+	}
+
+	return checkoutItems
+}
+
+func getCartProductIDs(items []types.CartItem) []int {
+	productIDs := make([]int, len(items))
+	for i, item := range items {
+		productIDs[i] = item.ProductID
+	}
+
+	return productIDs
+}
+
+// buildCartView prices the cart against the current catalog and flags items
+// that can't be checked out as they are. Unavailable items don't count
+// towards the subtotal.
+func buildCartView(cart *types.Cart, products map[int]types.Product) (*types.CartView, error) {
+	view := &types.CartView{
+		ID:        cart.ID,
+		Items:     make([]types.CartLine, 0, len(cart.Items)),
+		Subtotal:  types.NewMoney(0, ""),
+		Warnings:  []string{},
+		UpdatedAt: cart.UpdatedAt,
+	}
+
+	for _, item := range cart.Items {
+		line := types.CartLine{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Quantity:  item.Quantity,
+		}
+
+		product, ok := products[item.ProductID]
+		variant, found := product.FindVariant(item.VariantID)
+		if !ok || !found {
+			line.Warning = "this item is no longer available"
+			view.Items = append(view.Items, line)
+			view.Warnings = append(view.Warnings, fmt.Sprintf("product %d: %s", item.ProductID, line.Warning))
+			continue
+		}
+
+		line.SKU = variant.SKU
+		line.Name = product.Name
+		line.Image = product.Image
+		line.Options = variant.Options
+		line.UnitPrice = variant.Price
+		line.Available = variant.Available
+
+		lineTotal, err := variant.Price.Mul(item.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		line.LineTotal = lineTotal
+
+		switch {
+		case variant.Available == 0:
+			line.Warning = "out of stock"
+		case variant.Available < item.Quantity:
+			line.Warning = fmt.Sprintf("only %d left in stock", variant.Available)
+		case item.Price.Amount != variant.Price.Amount:
+			line.Warning = fmt.Sprintf("price changed from %s to %s", item.Price, variant.Price)
+		}
+
+		if variant.Available > 0 {
+			view.Subtotal, err = view.Subtotal.Add(lineTotal)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if line.Warning != "" {
+			view.Warnings = append(view.Warnings, fmt.Sprintf("%s: %s", product.Name, line.Warning))
+		}
+
+		view.Items = append(view.Items, line)
 	}
 
-	return total
+	return view, nil
 }
@@ -0,0 +1,248 @@
+package cart
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// ErrCartNotFound is returned when a guest token doesn't match any cart.
+var ErrCartNotFound = errors.New("cart not found")
+
+func (s *Store) GetCartByID(id int) (*types.Cart, error) {
+	return s.getCart("id = $1", id)
+}
+
+func (s *Store) GetOrCreateUserCart(userID int) (*types.Cart, error) {
+	_, err := s.db.Exec("INSERT INTO carts (userId) VALUES ($1) ON CONFLICT (userId) DO NOTHING", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cart: %w", err)
+	}
+
+	return s.getCart("userId = $1", userID)
+}
+
+func (s *Store) GetGuestCart(tokenHash string) (*types.Cart, error) {
+	return s.getCart("tokenHash = $1 AND userId IS NULL", tokenHash)
+}
+
+func (s *Store) CreateGuestCart(tokenHash string) (*types.Cart, error) {
+	if _, err := s.db.Exec("INSERT INTO carts (tokenHash) VALUES ($1)", tokenHash); err != nil {
+		return nil, fmt.Errorf("failed to create cart: %w", err)
+	}
+
+	return s.GetGuestCart(tokenHash)
+}
+
+// MergeGuestCart moves the items of a guest cart into the user's cart and
+// deletes the guest cart. Quantities of variants in both carts are summed and
+// capped at the variant's stock. A missing guest cart is not an error.
+func (s *Store) MergeGuestCart(tokenHash string, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var guestCartID int
+	err = tx.QueryRow("SELECT id FROM carts WHERE tokenHash = $1 AND userId IS NULL FOR UPDATE", tokenHash).Scan(&guestCartID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch guest cart: %w", err)
+	}
+
+	if _, err := tx.Exec("INSERT INTO carts (userId) VALUES ($1) ON CONFLICT (userId) DO NOTHING", userID); err != nil {
+		return fmt.Errorf("failed to create cart: %w", err)
+	}
+
+	var cartID int
+	if err := tx.QueryRow("SELECT id FROM carts WHERE userId = $1 FOR UPDATE", userID).Scan(&cartID); err != nil {
+		return fmt.Errorf("failed to fetch cart: %w", err)
+	}
+
+	mergeQuery := `
+			INSERT INTO cart_items (cartId, productId, variantId, quantity, price)
+			SELECT $1, productId, variantId, quantity, price FROM cart_items WHERE cartId = $2
+			ON CONFLICT (cartId, variantId) DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity;
+	`
+	if _, err := tx.Exec(mergeQuery, cartID, guestCartID); err != nil {
+		return fmt.Errorf("failed to merge cart items: %w", err)
+	}
+
+	// quantities are capped at what is available, the stock on hand less
+	// what is reserved for unpaid orders, as checkout does. Out of stock
+	// items are kept so the cart can warn about them
+	capQuery := `
+			UPDATE cart_items ci SET quantity = a.available
+			FROM (
+				SELECT v.id, v.quantity - COALESCE(SUM(r.quantity), 0) AS available
+				FROM product_variants v
+				LEFT JOIN inventory_reservations r ON r.variantId = v.id AND r.status = $2
+				WHERE v.id IN (SELECT variantId FROM cart_items WHERE cartId = $1)
+				GROUP BY v.id
+			) a
+			WHERE ci.variantId = a.id AND ci.cartId = $1 AND ci.quantity > a.available AND a.available > 0;
+	`
+	if _, err := tx.Exec(capQuery, cartID, types.ReservationStatusActive); err != nil {
+		return fmt.Errorf("failed to cap cart quantities: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM carts WHERE id = $1", guestCartID); err != nil {
+		return fmt.Errorf("failed to delete guest cart: %w", err)
+	}
+
+	if err := touchCart(tx, cartID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) getCart(where string, arg interface{}) (*types.Cart, error) {
+	cart := new(types.Cart)
+	err := s.db.QueryRow("SELECT id, userId, createdAt, updatedAt FROM carts WHERE "+where, arg).
+		Scan(&cart.ID, &cart.UserID, &cart.CreatedAt, &cart.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrCartNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch cart: %w", err)
+	}
+
+	cart.Items, err = s.getCartItems(cart.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+func (s *Store) getCartItems(cartID int) ([]types.CartItem, error) {
+	rows, err := s.db.Query("SELECT productId, variantId, quantity, price, createdAt FROM cart_items WHERE cartId = $1 ORDER BY createdAt, id", cartID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []types.CartItem{}
+	for rows.Next() {
+		var item types.CartItem
+		if err := rows.Scan(&item.ProductID, &item.VariantID, &item.Quantity, &item.Price, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// SetCartItem adds the item or replaces the quantity of the variant already
+// in the cart. The price of an existing item is kept.
+func (s *Store) SetCartItem(cartID int, item types.CartItem) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := upsertCartItems(tx, cartID, []types.CartItem{item}); err != nil {
+		return err
+	}
+
+	if err := touchCart(tx, cartID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) ReplaceCartItems(cartID int, items []types.CartItem) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM cart_items WHERE cartId = $1", cartID); err != nil {
+		return err
+	}
+
+	if err := upsertCartItems(tx, cartID, items); err != nil {
+		return err
+	}
+
+	if err := touchCart(tx, cartID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveCartItems removes a variant from the cart, or every variant of the
+// product when variantID is 0.
+func (s *Store) RemoveCartItems(cartID, productID, variantID int) error {
+	query := "DELETE FROM cart_items WHERE cartId = $1 AND productId = $2 AND ($3 = 0 OR variantId = $3)"
+
+	if _, err := s.db.Exec(query, cartID, productID, variantID); err != nil {
+		return fmt.Errorf("failed to remove cart items: %w", err)
+	}
+
+	_, err := s.db.Exec("UPDATE carts SET updatedAt = NOW() WHERE id = $1", cartID)
+	return err
+}
+
+func (s *Store) ClearCart(cartID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.ClearCartTx(tx, cartID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) ClearCartTx(tx *sql.Tx, cartID int) error {
+	if _, err := tx.Exec("DELETE FROM cart_items WHERE cartId = $1", cartID); err != nil {
+		return fmt.Errorf("failed to clear cart: %w", err)
+	}
+
+	return touchCart(tx, cartID)
+}
+
+func upsertCartItems(tx *sql.Tx, cartID int, items []types.CartItem) error {
+	query := `
+			INSERT INTO cart_items (cartId, productId, variantId, quantity, price)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (cartId, variantId) DO UPDATE SET quantity = EXCLUDED.quantity;
+	`
+
+	for _, item := range items {
+		if _, err := tx.Exec(query, cartID, item.ProductID, item.VariantID, item.Quantity, item.Price); err != nil {
+			return fmt.Errorf("failed to save cart item: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func touchCart(tx *sql.Tx, cartID int) error {
+	_, err := tx.Exec("UPDATE carts SET updatedAt = NOW() WHERE id = $1", cartID)
+	return err
+}
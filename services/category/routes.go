@@ -0,0 +1,294 @@
+package category
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/duziem/ecommerce_proj/services/auth"
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/duziem/ecommerce_proj/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	store        types.CategoryStore
+	productStore types.ProductStore
+	userStore    types.UserStore
+}
+
+func NewHandler(store types.CategoryStore, productStore types.ProductStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, productStore: productStore, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// get the category tree
+	router.HandleFunc("/categories", auth.WithJWTAuth(h.handleGetCategoryTree, h.userStore)).Methods(http.MethodGet)
+
+	// admin routes
+	// get a flat list of categories
+	router.HandleFunc("/admin/categories", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsRead, h.handleGetCategories, h.userStore), h.userStore)).Methods(http.MethodGet)
+	// create a category
+	router.HandleFunc("/admin/categories", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleCreateCategory, h.userStore), h.userStore)).Methods(http.MethodPost)
+	// update a category
+	router.HandleFunc("/admin/categories/{categoryID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleUpdateCategory, h.userStore), h.userStore)).Methods(http.MethodPatch)
+	// delete a category
+	router.HandleFunc("/admin/categories/{categoryID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleDeleteCategory, h.userStore), h.userStore)).Methods(http.MethodDelete)
+	// assign products to a category
+	router.HandleFunc("/admin/categories/{categoryID}/products", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleAssignProducts, h.userStore), h.userStore)).Methods(http.MethodPost)
+	// remove products from a category
+	router.HandleFunc("/admin/categories/{categoryID}/products", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleRemoveProducts, h.userStore), h.userStore)).Methods(http.MethodDelete)
+}
+
+func (h *Handler) handleGetCategoryTree(w http.ResponseWriter, r *http.Request) {
+	categories, err := h.store.GetCategories()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, buildCategoryTree(categories))
+}
+
+func (h *Handler) handleGetCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := h.store.GetCategories()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, categories)
+}
+
+func (h *Handler) handleCreateCategory(w http.ResponseWriter, r *http.Request) {
+	var payload types.CreateCategoryPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	if payload.Slug == "" {
+		payload.Slug = slugify(payload.Name)
+	}
+	if !isValidSlug(payload.Slug) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid slug %q", payload.Slug))
+		return
+	}
+
+	// check if the slug is taken
+	if _, err := h.store.GetCategoryBySlug(payload.Slug); err == nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("category with slug %s already exists", payload.Slug))
+		return
+	}
+
+	if payload.ParentID != nil {
+		if _, err := h.store.GetCategoryByID(*payload.ParentID); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("parent category not found"))
+			return
+		}
+	}
+
+	id, err := h.store.CreateCategory(payload)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	category, err := h.store.GetCategoryByID(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, category)
+}
+
+func (h *Handler) handleUpdateCategory(w http.ResponseWriter, r *http.Request) {
+	var payload types.UpdateCategoryPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	categoryID, err := getCategoryID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	category, err := h.store.GetCategoryByID(categoryID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	// Update only provided fields
+	if payload.Name != nil {
+		category.Name = *payload.Name
+	}
+	if payload.Description != nil {
+		category.Description = *payload.Description
+	}
+	if payload.Slug != nil && *payload.Slug != category.Slug {
+		if !isValidSlug(*payload.Slug) {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid slug %q", *payload.Slug))
+			return
+		}
+		if _, err := h.store.GetCategoryBySlug(*payload.Slug); err == nil {
+			utils.WriteError(w, http.StatusConflict, fmt.Errorf("category with slug %s already exists", *payload.Slug))
+			return
+		}
+		category.Slug = *payload.Slug
+	}
+	if payload.ParentID != nil {
+		if *payload.ParentID == 0 {
+			category.ParentID = nil
+		} else {
+			// a category can't be moved under itself or one of its descendants
+			descendants, err := h.store.GetDescendantIDs(category.ID)
+			if err != nil {
+				utils.WriteError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if slices.Contains(descendants, *payload.ParentID) {
+				utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("category cannot be moved under itself or its descendants"))
+				return
+			}
+			if _, err := h.store.GetCategoryByID(*payload.ParentID); err != nil {
+				utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("parent category not found"))
+				return
+			}
+			category.ParentID = payload.ParentID
+		}
+	}
+
+	if category.Name == "" {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("name cannot be empty"))
+		return
+	}
+
+	if err := h.store.UpdateCategory(*category); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, category)
+}
+
+func (h *Handler) handleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	categoryID, err := getCategoryID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := h.store.GetCategoryByID(categoryID); err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	descendants, err := h.store.GetDescendantIDs(categoryID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(descendants) > 1 {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("category has subcategories, move or delete them first"))
+		return
+	}
+
+	if err := h.store.DeleteCategory(categoryID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "category deleted successfully"})
+}
+
+func (h *Handler) handleAssignProducts(w http.ResponseWriter, r *http.Request) {
+	categoryID, payload, ok := h.parseCategoryProducts(w, r)
+	if !ok {
+		return
+	}
+
+	products, err := h.productStore.GetProductsByID(payload.ProductIDs)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(products) != len(uniqueIDs(payload.ProductIDs)) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("one or more products not found"))
+		return
+	}
+
+	if err := h.store.AssignProducts(categoryID, payload.ProductIDs); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "products assigned successfully"})
+}
+
+func (h *Handler) handleRemoveProducts(w http.ResponseWriter, r *http.Request) {
+	categoryID, payload, ok := h.parseCategoryProducts(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.RemoveProducts(categoryID, payload.ProductIDs); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "products removed successfully"})
+}
+
+func (h *Handler) parseCategoryProducts(w http.ResponseWriter, r *http.Request) (int, types.CategoryProductsPayload, bool) {
+	var payload types.CategoryProductsPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return 0, payload, false
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return 0, payload, false
+	}
+
+	categoryID, err := getCategoryID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return 0, payload, false
+	}
+
+	if _, err := h.store.GetCategoryByID(categoryID); err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return 0, payload, false
+	}
+
+	return categoryID, payload, true
+}
+
+func getCategoryID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	str, ok := vars["categoryID"]
+	if !ok {
+		return 0, fmt.Errorf("missing category ID")
+	}
+
+	categoryID, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid category ID")
+	}
+
+	return categoryID, nil
+}
@@ -0,0 +1,58 @@
+package category
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+var (
+	slugPattern    = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+	nonSlugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+func slugify(name string) string {
+	return strings.Trim(nonSlugPattern.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+func isValidSlug(slug string) bool {
+	return slugPattern.MatchString(slug)
+}
+
+// buildCategoryTree nests a flat list of categories under their parents and
+// returns the top-level categories.
+func buildCategoryTree(categories []*types.Category) []*types.Category {
+	byID := make(map[int]*types.Category, len(categories))
+	for _, c := range categories {
+		byID[c.ID] = c
+	}
+
+	roots := make([]*types.Category, 0)
+	for _, c := range categories {
+		parent, ok := byID[derefID(c.ParentID)]
+		if c.ParentID == nil || !ok {
+			roots = append(roots, c)
+			continue
+		}
+
+		parent.Children = append(parent.Children, c)
+	}
+
+	return roots
+}
+
+func derefID(id *int) int {
+	if id == nil {
+		return 0
+	}
+
+	return *id
+}
+
+func uniqueIDs(ids []int) []int {
+	unique := slices.Clone(ids)
+	slices.Sort(unique)
+	return slices.Compact(unique)
+}
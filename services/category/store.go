@@ -0,0 +1,171 @@
+package category
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/lib/pq"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetCategories() ([]*types.Category, error) {
+	rows, err := s.db.Query("SELECT id, parentId, name, slug, description, createdAt FROM categories ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := make([]*types.Category, 0)
+	for rows.Next() {
+		c, err := scanRowsIntoCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		categories = append(categories, c)
+	}
+
+	return categories, rows.Err()
+}
+
+func (s *Store) GetCategoryByID(id int) (*types.Category, error) {
+	return s.getCategory("SELECT id, parentId, name, slug, description, createdAt FROM categories WHERE id = $1", id)
+}
+
+func (s *Store) GetCategoryBySlug(slug string) (*types.Category, error) {
+	return s.getCategory("SELECT id, parentId, name, slug, description, createdAt FROM categories WHERE slug = $1", slug)
+}
+
+func (s *Store) getCategory(query string, arg interface{}) (*types.Category, error) {
+	rows, err := s.db.Query(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	c := new(types.Category)
+	for rows.Next() {
+		c, err = scanRowsIntoCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.ID == 0 {
+		return nil, fmt.Errorf("category not found")
+	}
+
+	return c, nil
+}
+
+// GetDescendantIDs returns the ID of the category followed by the IDs of
+// every category below it in the tree.
+func (s *Store) GetDescendantIDs(id int) ([]int, error) {
+	query := `
+			WITH RECURSIVE tree AS (
+				SELECT id FROM categories WHERE id = $1
+				UNION
+				SELECT c.id FROM categories c JOIN tree t ON c.parentId = t.id
+			)
+			SELECT id FROM tree;
+	`
+
+	rows, err := s.db.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var categoryID int
+		if err := rows.Scan(&categoryID); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, categoryID)
+	}
+
+	return ids, rows.Err()
+}
+
+func (s *Store) CreateCategory(category types.CreateCategoryPayload) (int, error) {
+	var id int
+	err := s.db.QueryRow("INSERT INTO categories (parentId, name, slug, description) VALUES ($1, $2, $3, $4) RETURNING id",
+		category.ParentID, category.Name, category.Slug, category.Description).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s *Store) UpdateCategory(category types.Category) error {
+	query := "UPDATE categories SET parentId = $1, name = $2, slug = $3, description = $4 WHERE id = $5"
+
+	_, err := s.db.Exec(query, category.ParentID, category.Name, category.Slug, category.Description, category.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteCategory(id int) error {
+	_, err := s.db.Exec("DELETE FROM categories WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) AssignProducts(categoryID int, productIDs []int) error {
+	query := `
+			INSERT INTO product_categories (productId, categoryId)
+			SELECT unnest($1::int[]), $2
+			ON CONFLICT DO NOTHING;
+	`
+
+	if _, err := s.db.Exec(query, pq.Array(productIDs), categoryID); err != nil {
+		return fmt.Errorf("failed to assign products: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) RemoveProducts(categoryID int, productIDs []int) error {
+	query := "DELETE FROM product_categories WHERE categoryId = $1 AND productId = ANY($2)"
+
+	if _, err := s.db.Exec(query, categoryID, pq.Array(productIDs)); err != nil {
+		return fmt.Errorf("failed to remove products: %w", err)
+	}
+
+	return nil
+}
+
+func scanRowsIntoCategory(rows *sql.Rows) (*types.Category, error) {
+	category := new(types.Category)
+
+	err := rows.Scan(
+		&category.ID,
+		&category.ParentID,
+		&category.Name,
+		&category.Slug,
+		&category.Description,
+		&category.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return category, nil
+}
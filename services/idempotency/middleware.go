@@ -0,0 +1,172 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/duziem/ecommerce_proj/configs"
+	"github.com/duziem/ecommerce_proj/services/auth"
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/duziem/ecommerce_proj/utils"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+
+	maxKeyLength   = 255
+	maxRequestBody = 1 << 20
+
+	saveAttempts   = 3
+	saveRetryDelay = 100 * time.Millisecond
+)
+
+// WithIdempotencyKey makes a handler safe to retry. The response to the first
+// request sent with an Idempotency-Key header is stored and replayed verbatim
+// for every retry with the same key and payload, and reusing the key for a
+// different payload is rejected with 422. Requests without the header are
+// passed through. It must run after auth.WithJWTAuth since keys are scoped
+// to the user.
+func WithIdempotencyKey(handlerFunc http.HandlerFunc, store types.IdempotencyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderKey)
+		if key == "" {
+			handlerFunc(w, r)
+			return
+		}
+
+		if len(key) > maxKeyLength {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("idempotency key is too long"))
+			return
+		}
+
+		userID := auth.GetUserIDFromContext(r.Context())
+
+		body, err := readBody(r)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		ttl := time.Second * time.Duration(configs.Envs.IdempotencyKeyTTLInSeconds)
+		record, claimed, err := store.ClaimIdempotencyKey(types.IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			RequestHash: hashRequest(r, body),
+			ExpiresAt:   time.Now().Add(ttl),
+		})
+		if errors.Is(err, ErrKeyInUse) {
+			utils.WriteError(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !claimed {
+			replay(w, r, record, body)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		handlerFunc(rec, r)
+
+		// server errors aren't stored so the client can retry with the same key
+		if rec.status >= http.StatusInternalServerError {
+			if err := store.DeleteIdempotencyKey(userID, key); err != nil {
+				log.Printf("failed to release idempotency key: %v", err)
+			}
+			return
+		}
+
+		// the request has had its effect, so a claim whose response can't be
+		// saved is never released: retries get 409 until the key expires
+		// rather than running the request again
+		for attempt := 1; ; attempt++ {
+			err := store.SaveIdempotencyResponse(userID, key, rec.status, rec.body.Bytes())
+			if err == nil {
+				break
+			}
+			if attempt == saveAttempts {
+				log.Printf("failed to store idempotent response, the key stays in flight until it expires: %v", err)
+				break
+			}
+
+			time.Sleep(saveRetryDelay)
+		}
+	}
+}
+
+func replay(w http.ResponseWriter, r *http.Request, record *types.IdempotencyKey, body []byte) {
+	if record.RequestHash != hashRequest(r, body) {
+		utils.WriteError(w, http.StatusUnprocessableEntity, fmt.Errorf("idempotency key was already used with a different request"))
+		return
+	}
+
+	if record.StatusCode == nil {
+		utils.WriteError(w, http.StatusConflict, ErrKeyInUse)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(*record.StatusCode)
+	w.Write(record.ResponseBody)
+}
+
+// readBody consumes the request body and puts a copy back for the handler.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBody+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %v", err)
+	}
+	if len(body) > maxRequestBody {
+		return nil, fmt.Errorf("request body is too large")
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// hashRequest fingerprints the method, path and body so a key can't be
+// replayed against a different request.
+func hashRequest(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,93 @@
+package idempotency
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+// ErrKeyInUse means another request with the key hasn't finished, or released
+// the key before its record could be read.
+var ErrKeyInUse = errors.New("a request with this idempotency key is still being processed")
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// ClaimIdempotencyKey records the key for a new request. When the key is
+// already in use it returns the existing record and false; an expired record
+// is taken over as if the key were new.
+func (s *Store) ClaimIdempotencyKey(key types.IdempotencyKey) (*types.IdempotencyKey, bool, error) {
+	// expired keys of the user are pruned on the way so the table doesn't grow unbounded
+	if _, err := s.db.Exec("DELETE FROM idempotency_keys WHERE userId = $1 AND expiresAt <= NOW()", key.UserID); err != nil {
+		return nil, false, fmt.Errorf("failed to prune idempotency keys: %w", err)
+	}
+
+	query := `
+			INSERT INTO idempotency_keys (userId, key, requestHash, expiresAt)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (userId, key) DO UPDATE
+			SET requestHash = EXCLUDED.requestHash,
+			    statusCode = NULL,
+			    responseBody = NULL,
+			    createdAt = NOW(),
+			    expiresAt = EXCLUDED.expiresAt
+			WHERE idempotency_keys.expiresAt <= NOW()
+			RETURNING userId;
+	`
+
+	var userID int
+	err := s.db.QueryRow(query, key.UserID, key.Key, key.RequestHash, key.ExpiresAt.UTC()).Scan(&userID)
+	if err == nil {
+		return &key, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+
+	existing := new(types.IdempotencyKey)
+	err = s.db.QueryRow(`
+			SELECT userId, key, requestHash, statusCode, responseBody, createdAt, expiresAt
+			FROM idempotency_keys
+			WHERE userId = $1 AND key = $2`, key.UserID, key.Key).Scan(
+		&existing.UserID,
+		&existing.Key,
+		&existing.RequestHash,
+		&existing.StatusCode,
+		&existing.ResponseBody,
+		&existing.CreatedAt,
+		&existing.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, false, ErrKeyInUse
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch idempotency key: %w", err)
+	}
+
+	return existing, false, nil
+}
+
+func (s *Store) SaveIdempotencyResponse(userID int, key string, statusCode int, body []byte) error {
+	query := "UPDATE idempotency_keys SET statusCode = $1, responseBody = $2 WHERE userId = $3 AND key = $4"
+
+	if _, err := s.db.Exec(query, statusCode, body, userID, key); err != nil {
+		return fmt.Errorf("failed to save idempotent response: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteIdempotencyKey(userID int, key string) error {
+	if _, err := s.db.Exec("DELETE FROM idempotency_keys WHERE userId = $1 AND key = $2", userID, key); err != nil {
+		return fmt.Errorf("failed to delete idempotency key: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/duziem/ecommerce_proj/services/order"
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+// sweepBatchSize caps how many orders a single sweep cancels
+const sweepBatchSize = 100
+
+// Sweeper cancels orders whose stock reservation expired before they were
+// paid for, which releases the reserved stock.
+type Sweeper struct {
+	orderStore   types.OrderStore
+	productStore types.ProductStore
+	interval     time.Duration
+}
+
+func NewSweeper(orderStore types.OrderStore, productStore types.ProductStore, interval time.Duration) *Sweeper {
+	return &Sweeper{orderStore: orderStore, productStore: productStore, interval: interval}
+}
+
+// Run sweeps every interval until ctx is done.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := s.Sweep(time.Now()); err != nil {
+				log.Printf("reservation sweep failed: %v", err)
+			} else if n > 0 {
+				log.Printf("released the expired reservations of %d orders", n)
+			}
+		}
+	}
+}
+
+// Sweep cancels the orders with reservations that expired before now and
+// returns how many were cancelled.
+func (s *Sweeper) Sweep(now time.Time) (int, error) {
+	released := 0
+	for {
+		orderIDs, err := s.productStore.GetExpiredReservationOrderIDs(now, sweepBatchSize)
+		if err != nil {
+			return released, err
+		}
+
+		cancelled := 0
+		for _, id := range orderIDs {
+			err := order.CancelOrder(s.orderStore, s.productStore, id, 0)
+			if errors.Is(err, order.ErrOrderNotCancellable) || errors.Is(err, order.ErrOrderStatusChanged) {
+				// paid or cancelled in the meantime
+				continue
+			}
+			if err != nil {
+				log.Printf("failed to cancel order %d with an expired reservation: %v", id, err)
+				continue
+			}
+
+			cancelled++
+		}
+		released += cancelled
+
+		// a full batch that made no progress would be fetched again forever
+		if len(orderIDs) < sweepBatchSize || cancelled == 0 {
+			return released, nil
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+// Mailer delivers an email from the outbox.
+type Mailer interface {
+	Send(ctx context.Context, email types.Email) error
+}
+
+// SMTPMailer sends emails through an SMTP server, authenticating when a
+// username is set.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{addr: host + ":" + strconv.Itoa(port), from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, email types.Email) error {
+	msg, err := buildMessage(m.from, email)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{email.To}, msg)
+}
+
+// LogMailer is for local development and tests. It logs every email and,
+// when it has a directory, writes them there as .eml files instead of
+// logging their body.
+type LogMailer struct {
+	dir  string
+	from string
+}
+
+func NewLogMailer(dir, from string) *LogMailer {
+	return &LogMailer{dir: dir, from: from}
+}
+
+func (m *LogMailer) Send(ctx context.Context, email types.Email) error {
+	if m.dir == "" {
+		log.Printf("email to %s: %s\n%s", email.To, email.Subject, email.Body)
+		return nil
+	}
+
+	msg, err := buildMessage(m.from, email)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+
+	name := filepath.Join(m.dir, fmt.Sprintf("%d-%d.eml", time.Now().UnixNano(), email.ID))
+	if err := os.WriteFile(name, msg, 0o644); err != nil {
+		return err
+	}
+
+	log.Printf("email to %s: %s, written to %s", email.To, email.Subject, name)
+	return nil
+}
+
+// buildMessage formats a plain text email.
+func buildMessage(from string, email types.Email) ([]byte, error) {
+	// a line break in a header would let it add headers of its own
+	for _, header := range []string{from, email.To, email.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, fmt.Errorf("invalid email header %q", header)
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", email.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("\r\n")
+
+	body := strings.ReplaceAll(email.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return buf.Bytes(), nil
+}
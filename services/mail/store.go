@@ -0,0 +1,100 @@
+package mail
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+// emailColumns lists the columns read by scanRowsIntoEmail, in scan order
+const emailColumns = "id, recipient, subject, body, status, attempts, COALESCE(lastError, ''), nextAttemptAt, sentAt, createdAt"
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// EnqueueEmail adds an email to the outbox in the transaction of the change
+// it is about, so it is only sent if that change is committed.
+func (s *Store) EnqueueEmail(tx *sql.Tx, email types.Email) error {
+	_, err := tx.Exec("INSERT INTO email_outbox (recipient, subject, body, nextAttemptAt) VALUES ($1, $2, $3, $4)",
+		email.To, email.Subject, email.Body, time.Now().UTC())
+	return err
+}
+
+// ClaimPendingEmails returns up to limit emails that are due and counts an
+// attempt for each. They aren't due again until the lease is over, so
+// other workers leave them alone while they are being sent.
+func (s *Store) ClaimPendingEmails(now time.Time, lease time.Duration, limit int) ([]types.Email, error) {
+	rows, err := s.db.Query(`
+		UPDATE email_outbox SET attempts = attempts + 1, nextAttemptAt = $2
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE status = $3 AND nextAttemptAt <= $1
+			ORDER BY nextAttemptAt
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+emailColumns,
+		now.UTC(), now.Add(lease).UTC(), types.EmailStatusPending, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []types.Email{}
+	for rows.Next() {
+		email, err := scanRowsIntoEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, *email)
+	}
+
+	return emails, rows.Err()
+}
+
+func (s *Store) MarkEmailSent(id int) error {
+	_, err := s.db.Exec("UPDATE email_outbox SET status = $1, sentAt = NOW(), lastError = NULL WHERE id = $2", types.EmailStatusSent, id)
+	return err
+}
+
+// MarkEmailFailed records a failed attempt. The email is retried at
+// nextAttemptAt, or given up on when that is nil.
+func (s *Store) MarkEmailFailed(id int, lastError string, nextAttemptAt *time.Time) error {
+	if nextAttemptAt == nil {
+		_, err := s.db.Exec("UPDATE email_outbox SET status = $1, lastError = $2 WHERE id = $3", types.EmailStatusFailed, lastError, id)
+		return err
+	}
+
+	_, err := s.db.Exec("UPDATE email_outbox SET lastError = $1, nextAttemptAt = $2 WHERE id = $3", lastError, nextAttemptAt.UTC(), id)
+	return err
+}
+
+func scanRowsIntoEmail(rows *sql.Rows) (*types.Email, error) {
+	email := new(types.Email)
+
+	err := rows.Scan(
+		&email.ID,
+		&email.To,
+		&email.Subject,
+		&email.Body,
+		&email.Status,
+		&email.Attempts,
+		&email.LastError,
+		&email.NextAttemptAt,
+		&email.SentAt,
+		&email.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return email, nil
+}
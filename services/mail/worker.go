@@ -0,0 +1,93 @@
+package mail
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+const (
+	// sendBatchSize caps how many emails are claimed at a time
+	sendBatchSize = 50
+	// maxAttempts is how many times an email is tried before it is marked failed
+	maxAttempts = 5
+	// sendLease is how long a claimed email is left to its worker before
+	// another one may try it
+	sendLease = 5 * time.Minute
+)
+
+// Worker sends the emails in the outbox, retrying failures with a growing
+// delay.
+type Worker struct {
+	store    types.MailStore
+	mailer   Mailer
+	interval time.Duration
+}
+
+func NewWorker(store types.MailStore, mailer Mailer, interval time.Duration) *Worker {
+	return &Worker{store: store, mailer: mailer, interval: interval}
+}
+
+// Run sends the due emails every interval until ctx is done.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := w.Send(ctx, time.Now()); err != nil {
+				log.Printf("sending emails failed: %v", err)
+			}
+		}
+	}
+}
+
+// Send sends the emails that are due at now and returns how many were sent.
+func (w *Worker) Send(ctx context.Context, now time.Time) (int, error) {
+	sent := 0
+	for {
+		emails, err := w.store.ClaimPendingEmails(now, sendLease, sendBatchSize)
+		if err != nil {
+			return sent, err
+		}
+
+		for _, email := range emails {
+			if err := w.mailer.Send(ctx, email); err != nil {
+				w.fail(email, err, now)
+				continue
+			}
+
+			if err := w.store.MarkEmailSent(email.ID); err != nil {
+				// it will be sent again once its lease is over
+				log.Printf("failed to mark email %d as sent: %v", email.ID, err)
+				continue
+			}
+			sent++
+		}
+
+		if len(emails) < sendBatchSize {
+			return sent, nil
+		}
+	}
+}
+
+// fail schedules the next attempt at an email, doubling the delay each time,
+// or gives up on it after maxAttempts.
+func (w *Worker) fail(email types.Email, sendErr error, now time.Time) {
+	log.Printf("failed to send email %d to %s (attempt %d): %v", email.ID, email.To, email.Attempts, sendErr)
+
+	var nextAttemptAt *time.Time
+	if email.Attempts < maxAttempts {
+		next := now.Add(time.Minute << (email.Attempts - 1))
+		nextAttemptAt = &next
+	}
+
+	if err := w.store.MarkEmailFailed(email.ID, sendErr.Error(), nextAttemptAt); err != nil {
+		log.Printf("failed to record the failure of email %d: %v", email.ID, err)
+	}
+}
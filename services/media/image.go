@@ -0,0 +1,140 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+// ThumbnailSize is the largest width or height of a thumbnail.
+type ThumbnailSize struct {
+	Name string
+	Max  int
+}
+
+var ThumbnailSizes = []ThumbnailSize{
+	{Name: "small", Max: 150},
+	{Name: "medium", Max: 400},
+	{Name: "large", Max: 800},
+}
+
+// maxImagePixels guards against images that are small files but decode to
+// huge bitmaps
+const maxImagePixels = 40_000_000
+
+// imageExtensions are the image types that can be uploaded, by content type
+var imageExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// Image is a decoded upload.
+type Image struct {
+	image.Image
+	ContentType string
+	Extension   string
+}
+
+// DecodeImage sniffs the type of the upload from its content, whatever name
+// or content type the client gave it, and decodes it.
+func DecodeImage(data []byte) (*Image, error) {
+	contentType := http.DetectContentType(data)
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported image type %s, upload a jpeg, png or gif", contentType)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %v", err)
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, fmt.Errorf("image is too large, %dx%d pixels", config.Width, config.Height)
+	}
+
+	var img image.Image
+	switch contentType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		img, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %v", err)
+	}
+
+	return &Image{Image: img, ContentType: contentType, Extension: ext}, nil
+}
+
+// Thumbnail scales the image down to fit in a size by size square, keeping
+// its aspect ratio. Images that already fit are returned as they are.
+func (img *Image) Thumbnail(size int) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw <= size && sh <= size {
+		return img.Image
+	}
+
+	dw, dh := size, size
+	if sw >= sh {
+		dh = max(1, sh*size/sw)
+	} else {
+		dw = max(1, sw*size/sh)
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(src, src.Bounds(), img.Image, b.Min, draw.Src)
+
+	// every thumbnail pixel averages the block of source pixels it covers
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		y0 := dy * sh / dh
+		y1 := max((dy+1)*sh/dh, y0+1)
+
+		for dx := 0; dx < dw; dx++ {
+			x0 := dx * sw / dw
+			x1 := max((dx+1)*sw/dw, x0+1)
+
+			var r, g, bl, a, n uint64
+			for y := y0; y < y1; y++ {
+				i := src.PixOffset(x0, y)
+				for x := x0; x < x1; x++ {
+					r += uint64(src.Pix[i])
+					g += uint64(src.Pix[i+1])
+					bl += uint64(src.Pix[i+2])
+					a += uint64(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+
+			j := dst.PixOffset(dx, dy)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(bl / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// EncodeThumbnail writes a thumbnail of the image, as a jpeg for jpegs and as
+// a png otherwise so transparency is kept. It returns the content type and
+// extension it used.
+func (img *Image) EncodeThumbnail(w io.Writer, thumbnail image.Image) (string, string, error) {
+	if img.ContentType == "image/jpeg" {
+		return "image/jpeg", "jpg", jpeg.Encode(w, thumbnail, &jpeg.Options{Quality: 85})
+	}
+
+	return "image/png", "png", png.Encode(w, thumbnail)
+}
@@ -0,0 +1,50 @@
+package media
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/duziem/ecommerce_proj/utils"
+	"github.com/gorilla/mux"
+)
+
+// cacheControl lets clients keep files forever, since a key is never reused
+// for different content
+const cacheControl = "public, max-age=31536000, immutable"
+
+type Handler struct {
+	storage Storage
+	prefix  string
+}
+
+// NewHandler serves the files in storage under the URL path prefix, e.g. "/media/".
+func NewHandler(storage Storage, prefix string) *Handler {
+	return &Handler{storage: storage, prefix: prefix}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// serve uploaded files
+	router.PathPrefix(h.prefix).HandlerFunc(h.handleGetFile).Methods(http.MethodGet, http.MethodHead)
+}
+
+func (h *Handler) handleGetFile(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, h.prefix)
+
+	f, err := h.storage.Open(r.Context(), key)
+	if errors.Is(err, ErrNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// ServeContent handles ranges and conditional requests
+	http.ServeContent(w, r, key, f.ModTime, f)
+}
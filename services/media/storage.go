@@ -0,0 +1,127 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("file not found")
+
+// Storage keeps uploaded files under slash separated keys such as
+// "products/1/ab12/large.jpg". The local filesystem is the only backend so
+// far, an S3-compatible one would implement the same interface.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	Open(ctx context.Context, key string) (*File, error)
+	Delete(ctx context.Context, key string) error
+	// URL is the public address the file is served from
+	URL(key string) string
+}
+
+// File is a stored file opened for reading.
+type File struct {
+	io.ReadSeekCloser
+	Size    int64
+	ModTime time.Time
+}
+
+// LocalStorage stores files in a directory and serves them from baseURL.
+type LocalStorage struct {
+	root    string
+	baseURL string
+}
+
+func NewLocalStorage(root, baseURL string) *LocalStorage {
+	return &LocalStorage{root: root, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", key, err)
+	}
+
+	// write to a temporary file first so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to store %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to store %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to store %s: %w", key, err)
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *LocalStorage) Open(ctx context.Context, key string) (*File, error) {
+	// keys that can't name a file are reported as missing
+	name, err := s.path(key)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, ErrNotFound
+	}
+
+	return &File{ReadSeekCloser: f, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *LocalStorage) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+// path maps a key to a file under root, rejecting keys that would escape it.
+func (s *LocalStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || clean != "/"+key {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,215 @@
+package order
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+
+	maxSearchLength = 200
+)
+
+// sortable order columns
+var sortColumns = map[string]string{
+	"createdAt": "createdAt",
+	"total":     "total",
+	"id":        "id",
+}
+
+func parseOrderQueryOptions(query url.Values) (types.OrderQueryOptions, error) {
+	opts := types.OrderQueryOptions{
+		Limit:     defaultPageLimit,
+		SortBy:    "createdAt",
+		SortOrder: "desc",
+	}
+
+	if str := query.Get("limit"); str != "" {
+		limit, err := strconv.Atoi(str)
+		if err != nil || limit <= 0 {
+			return opts, fmt.Errorf("invalid limit")
+		}
+		opts.Limit = min(limit, maxPageLimit)
+	}
+
+	if str := query.Get("offset"); str != "" {
+		offset, err := strconv.Atoi(str)
+		if err != nil || offset < 0 {
+			return opts, fmt.Errorf("invalid offset")
+		}
+		opts.Offset = offset
+	}
+
+	// status=paid,shipped matches either status
+	if str := query.Get("status"); str != "" {
+		for _, s := range strings.Split(str, ",") {
+			status := types.OrderStatus(strings.TrimSpace(s))
+			if !status.IsValid() {
+				return opts, fmt.Errorf("invalid status %s", status)
+			}
+			opts.Statuses = append(opts.Statuses, status)
+		}
+	}
+
+	if str := query.Get("userID"); str != "" {
+		userID, err := strconv.Atoi(str)
+		if err != nil || userID <= 0 {
+			return opts, fmt.Errorf("invalid userID")
+		}
+		opts.UserID = userID
+	}
+
+	if str := query.Get("productID"); str != "" {
+		productID, err := strconv.Atoi(str)
+		if err != nil || productID <= 0 {
+			return opts, fmt.Errorf("invalid productID")
+		}
+		opts.ProductID = productID
+	}
+
+	if str := strings.TrimSpace(query.Get("q")); str != "" {
+		if len(str) > maxSearchLength {
+			return opts, fmt.Errorf("search query is too long")
+		}
+		opts.Search = str
+	}
+
+	if str := query.Get("from"); str != "" {
+		from, err := parseTime(str)
+		if err != nil {
+			return opts, fmt.Errorf("invalid from, expected RFC3339 or YYYY-MM-DD")
+		}
+		opts.CreatedFrom = &from
+	}
+
+	// a bare date includes the whole day
+	if str := query.Get("to"); str != "" {
+		to, err := parseTime(str)
+		if err != nil {
+			return opts, fmt.Errorf("invalid to, expected RFC3339 or YYYY-MM-DD")
+		}
+		if len(str) == len("2006-01-02") {
+			to = to.AddDate(0, 0, 1)
+		}
+		opts.CreatedTo = &to
+	}
+
+	if opts.CreatedFrom != nil && opts.CreatedTo != nil && !opts.CreatedFrom.Before(*opts.CreatedTo) {
+		return opts, fmt.Errorf("from must be before to")
+	}
+
+	if str := query.Get("minTotal"); str != "" {
+		total, err := types.ParseMoney(str, "")
+		if err != nil || total.Amount < 0 {
+			return opts, fmt.Errorf("invalid minTotal")
+		}
+		opts.MinTotal = &total
+	}
+
+	if str := query.Get("maxTotal"); str != "" {
+		total, err := types.ParseMoney(str, "")
+		if err != nil || total.Amount < 0 {
+			return opts, fmt.Errorf("invalid maxTotal")
+		}
+		opts.MaxTotal = &total
+	}
+
+	if opts.MinTotal != nil && opts.MaxTotal != nil && opts.MinTotal.Amount > opts.MaxTotal.Amount {
+		return opts, fmt.Errorf("minTotal cannot be greater than maxTotal")
+	}
+
+	if str := query.Get("sortBy"); str != "" {
+		if _, ok := sortColumns[str]; !ok {
+			return opts, fmt.Errorf("invalid sortBy, expected one of createdAt, total, id")
+		}
+		opts.SortBy = str
+	}
+
+	if str := query.Get("sortOrder"); str != "" {
+		str = strings.ToLower(str)
+		if str != "asc" && str != "desc" {
+			return opts, fmt.Errorf("invalid sortOrder, expected asc or desc")
+		}
+		opts.SortOrder = str
+	}
+
+	return opts, nil
+}
+
+func parseTime(str string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t, nil
+	}
+
+	return time.Parse("2006-01-02", str)
+}
+
+// buildOrderFilters returns the WHERE conditions shared by the page query and
+// its count, along with their positional arguments.
+func buildOrderFilters(opts types.OrderQueryOptions) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if len(opts.Statuses) > 0 {
+		statuses := make([]string, len(opts.Statuses))
+		for i, s := range opts.Statuses {
+			statuses[i] = string(s)
+		}
+		args = append(args, pq.Array(statuses))
+		conditions = append(conditions, fmt.Sprintf("status = ANY($%d)", len(args)))
+	}
+	if opts.UserID > 0 {
+		args = append(args, opts.UserID)
+		conditions = append(conditions, fmt.Sprintf("userId = $%d", len(args)))
+	}
+	if opts.ProductID > 0 {
+		args = append(args, opts.ProductID)
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM order_items oi WHERE oi.orderId = orders.id AND oi.productId = $%d)", len(args)))
+	}
+	if opts.Search != "" {
+		// matches the customer's name or email, or the shipping address
+		args = append(args, "%"+escapeLike(opts.Search)+"%")
+		conditions = append(conditions, fmt.Sprintf(`(address ILIKE $%[1]d OR EXISTS (
+			SELECT 1 FROM users u WHERE u.id = orders.userId
+			AND (u.email ILIKE $%[1]d OR u.firstName || ' ' || u.lastName ILIKE $%[1]d)))`, len(args)))
+	}
+	if opts.CreatedFrom != nil {
+		args = append(args, opts.CreatedFrom.UTC())
+		conditions = append(conditions, fmt.Sprintf("createdAt >= $%d", len(args)))
+	}
+	if opts.CreatedTo != nil {
+		args = append(args, opts.CreatedTo.UTC())
+		conditions = append(conditions, fmt.Sprintf("createdAt < $%d", len(args)))
+	}
+	if opts.MinTotal != nil {
+		args = append(args, *opts.MinTotal)
+		conditions = append(conditions, fmt.Sprintf("total >= $%d", len(args)))
+	}
+	if opts.MaxTotal != nil {
+		args = append(args, *opts.MaxTotal)
+		conditions = append(conditions, fmt.Sprintf("total <= $%d", len(args)))
+	}
+
+	return conditions, args
+}
+
+func escapeLike(str string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(str)
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
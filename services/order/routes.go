@@ -1,6 +1,8 @@
 package order
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -8,61 +10,130 @@ import (
 	"github.com/duziem/ecommerce_proj/services/auth"
 	"github.com/duziem/ecommerce_proj/types"
 	"github.com/duziem/ecommerce_proj/utils"
-	"github.com/go-playground/validator"
+	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 )
 
+// Canceller cancels an order and refunds what was paid for it, see
+// payments.Service.
+type Canceller interface {
+	CancelOrder(ctx context.Context, orderID int) error
+}
+
 type Handler struct {
-	store     types.OrderStore
-	userStore types.UserStore
+	store        types.OrderStore
+	productStore types.ProductStore
+	userStore    types.UserStore
+	payments     Canceller
 }
 
-func NewHandler(store types.OrderStore, userStore types.UserStore) *Handler {
-	return &Handler{store: store, userStore: userStore}
+func NewHandler(store types.OrderStore, productStore types.ProductStore, userStore types.UserStore, payments Canceller) *Handler {
+	return &Handler{store: store, productStore: productStore, userStore: userStore, payments: payments}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
 	// get a list of orders for a user
 	router.HandleFunc("/orders", auth.WithJWTAuth(h.handleOrders, h.userStore)).Methods(http.MethodGet)
+	// get an order with its items
+	router.HandleFunc("/orders/{orderID}", auth.WithJWTAuth(h.handleGetOrder, h.userStore)).Methods(http.MethodGet)
 	// cancel an order
 	router.HandleFunc("/orders/{orderID}", auth.WithJWTAuth(h.cancelOrderStatusUpdate, h.userStore)).Methods(http.MethodPatch)
 
 	// admin routes
+	// get a page of all orders, see parseOrderQueryOptions for the filters
+	router.HandleFunc("/admin/orders", auth.WithJWTAuth(auth.RequirePermission(types.PermissionOrdersRead, h.handleGetAllOrders, h.userStore), h.userStore)).Methods(http.MethodGet)
+	// get any order with its items and customer
+	router.HandleFunc("/admin/orders/{orderID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionOrdersRead, h.handleAdminGetOrder, h.userStore), h.userStore)).Methods(http.MethodGet)
 	// update the status of an order
-	router.HandleFunc("/admin/orders/{orderID}", auth.WithJWTAuth(auth.WithAdminRole(h.handleOrderStatusUpdate, h.userStore), h.userStore)).Methods(http.MethodPatch)
+	router.HandleFunc("/admin/orders/{orderID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionOrdersWrite, h.handleOrderStatusUpdate, h.userStore), h.userStore)).Methods(http.MethodPatch)
 }
 
-func (h *Handler) cancelOrderStatusUpdate(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	str, ok := vars["orderID"]
-	if !ok {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("missing order ID"))
+func (h *Handler) handleGetOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := getOrderID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	orderID, err := strconv.Atoi(str)
+	// other users' orders are reported as missing rather than forbidden
+	order, err := h.store.GetOrderByID(orderID)
+	if err != nil || order.UserID != auth.GetUserIDFromContext(r.Context()) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order not found"))
+		return
+	}
+
+	items, err := h.store.GetOrderItems(order.ID)
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order ID"))
+		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	order, err := h.store.GetOrderByID(orderID)
+	utils.WriteJSON(w, http.StatusOK, types.OrderDetail{Order: *order, Items: items})
+}
+
+func (h *Handler) handleGetAllOrders(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseOrderQueryOptions(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := h.store.GetAllOrders(opts)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if order.Status != "pending" {
-		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("invalid order status"))
+	utils.WriteJSON(w, http.StatusOK, page)
+}
+
+func (h *Handler) handleAdminGetOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := getOrderID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	order, err := h.store.GetOrderByID(orderID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	items, err := h.store.GetOrderItems(order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	err = h.store.UpdateOrderStatus(order, "cancelled")
+	customer, err := h.userStore.GetUserByID(order.UserID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	utils.WriteJSON(w, http.StatusOK, types.OrderDetail{Order: *order, Items: items, Customer: customer})
+}
+
+func (h *Handler) cancelOrderStatusUpdate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	str, ok := vars["orderID"]
+	if !ok {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("missing order ID"))
+		return
+	}
+
+	orderID, err := strconv.Atoi(str)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order ID"))
+		return
+	}
+
+	userID := auth.GetUserIDFromContext(r.Context())
+	if err := h.cancelOrder(w, orderID, userID); err != nil {
+		return
+	}
+
 	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "order updated successfully"})
 }
 
@@ -75,6 +146,14 @@ func (h *Handler) handleOrderStatusUpdate(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// orders are paid and refunded by the payments and returns flows, which
+	// capture and refund the money too
+	switch orderPayload.Status {
+	case types.OrderStatusPaid, types.OrderStatusRefunded, types.OrderStatusPartiallyRefunded:
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("order status cannot be set to %s by hand", orderPayload.Status))
+		return
+	}
+
 	// Validate the payload (if needed)
 	if err := utils.Validate.Struct(orderPayload); err != nil {
 		errors := err.(validator.ValidationErrors)
@@ -95,21 +174,75 @@ func (h *Handler) handleOrderStatusUpdate(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// cancelling also puts the items back in stock and refunds the payment
+	if orderPayload.Status == types.OrderStatusCancelled {
+		if err := h.payments.CancelOrder(r.Context(), orderID); err != nil {
+			writeOrderError(w, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "order updated successfully"})
+		return
+	}
+
 	order, err := h.store.GetOrderByID(orderID)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		utils.WriteError(w, http.StatusNotFound, err)
 		return
 	}
 
-	err = h.store.UpdateOrderStatus(order, orderPayload.Status)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+	if err := h.updateOrderStatus(w, order, orderPayload.Status); err != nil {
 		return
 	}
 
 	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "order updated successfully"})
 }
 
+// updateOrderStatus applies a status transition, writing an error response
+// when it isn't allowed or fails.
+func (h *Handler) updateOrderStatus(w http.ResponseWriter, order *types.Order, status types.OrderStatus) error {
+	if !order.Status.CanTransitionTo(status) {
+		err := fmt.Errorf("cannot change order status from %s to %s", order.Status, status)
+		utils.WriteError(w, http.StatusConflict, err)
+		return err
+	}
+
+	err := h.store.UpdateOrderStatus(order, status)
+	if errors.Is(err, ErrOrderStatusChanged) {
+		utils.WriteError(w, http.StatusConflict, err)
+		return err
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return err
+	}
+
+	return nil
+}
+
+// cancelOrder cancels the order with CancelOrder, writing an error response
+// when it can't.
+func (h *Handler) cancelOrder(w http.ResponseWriter, orderID, userID int) error {
+	err := CancelOrder(h.store, h.productStore, orderID, userID)
+	if err != nil {
+		writeOrderError(w, err)
+	}
+
+	return err
+}
+
+// writeOrderError writes the response for an error from cancelling an order.
+func writeOrderError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrOrderNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrOrderNotCancellable), errors.Is(err, ErrOrderStatusChanged):
+		utils.WriteError(w, http.StatusConflict, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
+
 func (h *Handler) handleOrders(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserIDFromContext(r.Context())
 
@@ -121,3 +254,18 @@ func (h *Handler) handleOrders(w http.ResponseWriter, r *http.Request) {
 
 	utils.WriteJSON(w, http.StatusOK, orders)
 }
+
+func getOrderID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	str, ok := vars["orderID"]
+	if !ok {
+		return 0, fmt.Errorf("missing order ID")
+	}
+
+	orderID, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid order ID")
+	}
+
+	return orderID, nil
+}
@@ -0,0 +1,199 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+var (
+	ErrOrderNotFound       = errors.New("order not found")
+	ErrOrderNotCancellable = errors.New("order cannot be cancelled")
+	ErrOrderNotPayable     = errors.New("order cannot be marked paid")
+)
+
+// MarkOrderPaid moves a pending order to paid and takes the stock reserved
+// for it off the shelf, in one transaction.
+func MarkOrderPaid(store types.OrderStore, productStore types.ProductStore, orderID int) error {
+	tx, err := productStore.BeginTransaction()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	order, err := store.GetOrderByIDWithLock(tx, orderID)
+	if err != nil {
+		return ErrOrderNotFound
+	}
+
+	if !order.Status.CanTransitionTo(types.OrderStatusPaid) {
+		return fmt.Errorf("%w: it is %s", ErrOrderNotPayable, order.Status)
+	}
+
+	reservations, err := productStore.GetOrderReservationsWithLock(tx, order.ID)
+	if err != nil {
+		return err
+	}
+
+	var items []types.CartCheckoutItem
+	var movements []types.InventoryMovement
+	productIDs := make([]int, 0, len(reservations))
+	for _, r := range reservations {
+		if r.Status != types.ReservationStatusActive {
+			continue
+		}
+
+		items = append(items, types.CartCheckoutItem{ProductID: r.ProductID, VariantID: r.VariantID, Quantity: r.Quantity})
+		movements = append(movements, types.InventoryMovement{
+			ProductID: r.ProductID,
+			VariantID: &r.VariantID,
+			OrderID:   &order.ID,
+			Quantity:  -r.Quantity,
+			Reason:    types.InventoryReasonSale,
+		})
+		productIDs = append(productIDs, r.ProductID)
+	}
+
+	if len(items) > 0 {
+		if _, err := productStore.GetProductsByIDWithLock(tx, productIDs); err != nil {
+			return fmt.Errorf("failed to fetch products: %v", err)
+		}
+
+		if err := productStore.UpdateProductQuantities(tx, items); err != nil {
+			return err
+		}
+
+		if err := productStore.RecordInventoryMovements(tx, movements); err != nil {
+			return err
+		}
+
+		if err := productStore.UpdateReservationsStatus(tx, order.ID, types.ReservationStatusCommitted); err != nil {
+			return err
+		}
+	}
+
+	if err := store.UpdateOrderStatusTx(tx, order, types.OrderStatusPaid); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// CancelOrder cancels the order and puts its items back in stock, in one
+// transaction. Stock that is still only reserved for the order is released
+// instead, as it never left the shelf. When userID is set the order must
+// belong to that user and still be pending, a userID of 0 cancels on behalf
+// of an admin or the system.
+func CancelOrder(store types.OrderStore, productStore types.ProductStore, orderID, userID int) error {
+	tx, err := productStore.BeginTransaction()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the order so it can't be cancelled twice
+	order, err := store.GetOrderByIDWithLock(tx, orderID)
+	if err != nil {
+		return ErrOrderNotFound
+	}
+
+	// other users' orders are reported as missing rather than forbidden
+	if userID != 0 && order.UserID != userID {
+		return ErrOrderNotFound
+	}
+
+	// customers can only cancel orders that haven't been paid for
+	if userID != 0 && order.Status != types.OrderStatusPending {
+		return fmt.Errorf("%w: it is %s", ErrOrderNotCancellable, order.Status)
+	}
+
+	if !order.Status.CanTransitionTo(types.OrderStatusCancelled) {
+		return fmt.Errorf("%w: it is %s", ErrOrderNotCancellable, order.Status)
+	}
+
+	reservations, err := productStore.GetOrderReservationsWithLock(tx, order.ID)
+	if err != nil {
+		return err
+	}
+
+	if hasActiveReservations(reservations) {
+		if err := productStore.UpdateReservationsStatus(tx, order.ID, types.ReservationStatusReleased); err != nil {
+			return err
+		}
+
+		if err := store.UpdateOrderStatusTx(tx, order, types.OrderStatusCancelled); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %v", err)
+		}
+
+		return nil
+	}
+
+	items, err := store.GetOrderItemsTx(tx, order.ID)
+	if err != nil {
+		return err
+	}
+
+	// items whose variant has since been deleted have nothing to go back to
+	var restock []types.CartCheckoutItem
+	var movements []types.InventoryMovement
+	productIDs := make([]int, 0, len(items))
+	for _, item := range items {
+		if item.VariantID == nil {
+			continue
+		}
+
+		restock = append(restock, types.CartCheckoutItem{ProductID: item.ProductID, VariantID: *item.VariantID, Quantity: item.Quantity})
+		movements = append(movements, types.InventoryMovement{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			OrderID:   &order.ID,
+			Quantity:  item.Quantity,
+			Reason:    types.InventoryReasonCancellation,
+		})
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	if len(restock) > 0 {
+		// Lock the products and variants being restocked
+		if _, err := productStore.GetProductsByIDWithLock(tx, productIDs); err != nil {
+			return fmt.Errorf("failed to fetch products: %v", err)
+		}
+
+		if err := productStore.RestockProducts(tx, restock); err != nil {
+			return err
+		}
+
+		if err := productStore.RecordInventoryMovements(tx, movements); err != nil {
+			return err
+		}
+	}
+
+	if err := store.UpdateOrderStatusTx(tx, order, types.OrderStatusCancelled); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+func hasActiveReservations(reservations []types.InventoryReservation) bool {
+	for _, r := range reservations {
+		if r.Status == types.ReservationStatusActive {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,12 +2,27 @@ package order
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/duziem/ecommerce_proj/types"
 )
 
+// orderColumns lists the columns read by scanRowsIntoOrder, in scan order.
+const orderColumns = "id, userId, total, discount, shippingTotal, promotionId, status, address, createdAt"
+
+var ErrOrderStatusChanged = errors.New("order status was changed by another request")
+
+// orderItemColumns lists the columns read by scanRowsIntoOrderItem, in scan order.
+const orderItemColumns = "id, orderId, productId, variantId, productName, COALESCE(productImage, ''), sku, options, quantity, price, discount, createdAt"
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
 type Store struct {
 	db *sql.DB
 }
@@ -17,7 +32,7 @@ func NewStore(db *sql.DB) *Store {
 }
 
 func (s *Store) GetOrders(userID int) ([]*types.Order, error) {
-	rows, err := s.db.Query("SELECT * FROM orders WHERE userId = $1", userID)
+	rows, err := s.db.Query("SELECT "+orderColumns+" FROM orders WHERE userId = $1", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -39,11 +54,89 @@ func (s *Store) GetOrders(userID int) ([]*types.Order, error) {
 	return orders, nil
 }
 
+// GetAllOrders returns a page of every user's orders, for admins.
+func (s *Store) GetAllOrders(opts types.OrderQueryOptions) (*types.OrderPage, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = defaultPageLimit
+	}
+
+	conditions, args := buildOrderFilters(opts)
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM orders"+whereClause(conditions), args...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count orders: %w", err)
+	}
+
+	column, ok := sortColumns[opts.SortBy]
+	if !ok {
+		column = sortColumns["createdAt"]
+	}
+	order := "ASC"
+	if opts.SortOrder == "desc" {
+		order = "DESC"
+	}
+
+	args = append(args, opts.Limit, opts.Offset)
+	query := fmt.Sprintf("SELECT %s FROM orders%s ORDER BY %s %s, id %s LIMIT $%d OFFSET $%d",
+		orderColumns, whereClause(conditions), column, order, order, len(args)-1, len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch orders: %w", err)
+	}
+	defer rows.Close()
+
+	orders := make([]*types.Order, 0, opts.Limit)
+	for rows.Next() {
+		o, err := scanRowsIntoOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return &types.OrderPage{
+		Data: orders,
+		Metadata: types.PaginationMetadata{
+			TotalCount: total,
+			Limit:      opts.Limit,
+			Offset:     opts.Offset,
+		},
+	}, nil
+}
+
 func (s *Store) GetOrderByID(orderID int) (*types.Order, error) {
-	rows, err := s.db.Query("SELECT * FROM orders WHERE id = $1", orderID)
+	rows, err := s.db.Query("SELECT "+orderColumns+" FROM orders WHERE id = $1", orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	order := new(types.Order)
+	for rows.Next() {
+		order, err = scanRowsIntoOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if order.ID == 0 {
+		return nil, fmt.Errorf("order not found")
+	}
+
+	return order, nil
+}
+
+// GetOrderByIDWithLock locks the order until the transaction ends.
+func (s *Store) GetOrderByIDWithLock(tx *sql.Tx, orderID int) (*types.Order, error) {
+	rows, err := tx.Query("SELECT "+orderColumns+" FROM orders WHERE id = $1 FOR UPDATE", orderID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	order := new(types.Order)
 	for rows.Next() {
@@ -59,14 +152,67 @@ func (s *Store) GetOrderByID(orderID int) (*types.Order, error) {
 	return order, nil
 }
 
-func (s *Store) UpdateOrderStatus(order *types.Order, status string) error {
-	query := "UPDATE orders SET status = $1 WHERE id = $2"
+func (s *Store) GetOrderItems(orderID int) ([]types.OrderItem, error) {
+	return getOrderItems(s.db, orderID)
+}
+
+func (s *Store) GetOrderItemsTx(tx *sql.Tx, orderID int) ([]types.OrderItem, error) {
+	return getOrderItems(tx, orderID)
+}
+
+func getOrderItems(q querier, orderID int) ([]types.OrderItem, error) {
+	rows, err := q.Query("SELECT "+orderItemColumns+" FROM order_items WHERE orderId = $1 ORDER BY id", orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []types.OrderItem{}
+	for rows.Next() {
+		item, err := scanRowsIntoOrderItem(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, *item)
+	}
+
+	return items, rows.Err()
+}
+
+func (s *Store) UpdateOrderStatus(order *types.Order, status types.OrderStatus) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.UpdateOrderStatusTx(tx, order, status); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateOrderStatusTx moves the order to status, provided it still has the
+// status it was read with. ErrOrderStatusChanged is returned otherwise.
+func (s *Store) UpdateOrderStatusTx(tx *sql.Tx, order *types.Order, status types.OrderStatus) error {
+	query := "UPDATE orders SET status = $1 WHERE id = $2 AND status = $3"
 
-	_, err := s.db.Exec(query, status, order.ID)
+	res, err := tx.Exec(query, status, order.ID, order.Status)
 	if err != nil {
 		return err
 	}
 
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrOrderStatusChanged
+	}
+
+	order.Status = status
 	return nil
 }
 
@@ -75,13 +221,13 @@ func (s *Store) CreateOrder(tx *sql.Tx, order types.Order) (int, error) {
 
 	// SQL statement to insert a new order into the orders table
 	query := `
-			INSERT INTO orders (userId, total, status, address, createdAt)
-			VALUES ($1, $2, $3, $4, NOW())
+			INSERT INTO orders (userId, total, discount, shippingTotal, promotionId, status, address, createdAt)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
 			RETURNING id;
 	`
 
 	// Execute the query within the transaction
-	err := tx.QueryRow(query, order.UserID, order.Total, order.Status, order.Address).Scan(&orderID)
+	err := tx.QueryRow(query, order.UserID, order.Total, order.Discount, order.ShippingTotal, order.PromotionID, order.Status, order.Address).Scan(&orderID)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create order: %w", err)
 	}
@@ -89,17 +235,42 @@ func (s *Store) CreateOrder(tx *sql.Tx, order types.Order) (int, error) {
 	return orderID, nil
 }
 
-func (s *Store) CreateOrderItems(tx *sql.Tx, orderID int, cartItems []types.CartCheckoutItem, products map[int]types.Product) error {
+// CreateOrderItems records the items at their variant's current price, along
+// with a snapshot of the product. discounts lines up with cartItems and may be
+// nil when nothing was discounted.
+func (s *Store) CreateOrderItems(tx *sql.Tx, orderID int, cartItems []types.CartCheckoutItem, products map[int]types.Product, discounts []types.Money) error {
 	query := `
-			INSERT INTO order_items (orderid, productid, quantity, price)
+			INSERT INTO order_items (orderid, productid, variantid, productname, productimage, sku, options, quantity, price, discount, createdat)
 			VALUES %s;
 	`
 
 	var args []interface{}
 	var placeholders []string
 	for i, item := range cartItems {
-		placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d)", i*4+1, i*4+2, i*4+3, i*4+4))
-		args = append(args, orderID, item.ProductID, item.Quantity, products[item.ProductID].Price)
+		variant, ok := products[item.ProductID].FindVariant(item.VariantID)
+		if !ok {
+			return fmt.Errorf("variant %d not found for product %d", item.VariantID, item.ProductID)
+		}
+
+		discount := types.NewMoney(0, "")
+		if i < len(discounts) {
+			discount = discounts[i]
+		}
+
+		options, err := json.Marshal(variant.Options)
+		if err != nil {
+			return err
+		}
+		if variant.Options == nil {
+			options = []byte("{}")
+		}
+
+		product := products[item.ProductID]
+		n := i * 10
+		placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d, NULLIF($%d, ''), $%d, $%d, $%d, $%d, $%d, NOW())",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10))
+		args = append(args, orderID, item.ProductID, variant.ID, product.Name, product.Image, variant.SKU, options,
+			item.Quantity, variant.Price, discount)
 	}
 
 	finalQuery := fmt.Sprintf(query, strings.Join(placeholders, ", "))
@@ -111,6 +282,35 @@ func (s *Store) CreateOrderItems(tx *sql.Tx, orderID int, cartItems []types.Cart
 	return nil
 }
 
+func scanRowsIntoOrderItem(rows *sql.Rows) (*types.OrderItem, error) {
+	item := new(types.OrderItem)
+
+	var options []byte
+	err := rows.Scan(
+		&item.ID,
+		&item.OrderID,
+		&item.ProductID,
+		&item.VariantID,
+		&item.ProductName,
+		&item.ProductImage,
+		&item.SKU,
+		&options,
+		&item.Quantity,
+		&item.Price,
+		&item.Discount,
+		&item.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(options, &item.Options); err != nil {
+		return nil, fmt.Errorf("invalid options for order item %d: %w", item.ID, err)
+	}
+
+	return item, nil
+}
+
 func scanRowsIntoOrder(rows *sql.Rows) (*types.Order, error) {
 	order := new(types.Order)
 
@@ -118,6 +318,9 @@ func scanRowsIntoOrder(rows *sql.Rows) (*types.Order, error) {
 		&order.ID,
 		&order.UserID,
 		&order.Total,
+		&order.Discount,
+		&order.ShippingTotal,
+		&order.PromotionID,
 		&order.Status,
 		&order.Address,
 		&order.CreatedAt,
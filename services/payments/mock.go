@@ -0,0 +1,227 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+const (
+	MockProviderName = "mock"
+
+	// payment methods the mock provider treats specially, any other method
+	// is authorized and captured
+	MockMethodDeclined    = "tok_declined"
+	MockMethodCaptureFail = "tok_capture_fails"
+
+	// MockSignatureHeader carries "t=<unix time>,v1=<hex HMAC-SHA256 of "<t>.<body>">"
+	MockSignatureHeader = "Mock-Signature"
+
+	mockWebhookTolerance = 5 * time.Minute
+)
+
+// MockProvider is an in-memory payment provider for local development and
+// tests. It never talks to the network.
+type MockProvider struct {
+	secret []byte
+
+	mu       sync.Mutex
+	nextID   int
+	payments map[string]*mockPayment
+	// idempotency keys of the refunds made
+	refunds map[string]bool
+}
+
+type mockPayment struct {
+	method     string
+	authorized types.Money
+	captured   types.Money
+	refunded   types.Money
+	status     types.PaymentStatus
+}
+
+func NewMockProvider(webhookSecret string) *MockProvider {
+	return &MockProvider{
+		secret:   []byte(webhookSecret),
+		payments: make(map[string]*mockPayment),
+		refunds:  make(map[string]bool),
+	}
+}
+
+func (m *MockProvider) Name() string {
+	return MockProviderName
+}
+
+func (m *MockProvider) Authorize(ctx context.Context, req AuthorizeRequest) (*Authorization, error) {
+	if req.PaymentMethod == MockMethodDeclined {
+		return nil, fmt.Errorf("%w: card declined", ErrPaymentDeclined)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	ref := fmt.Sprintf("mock_pi_%d_%d", req.OrderID, m.nextID)
+	m.payments[ref] = &mockPayment{
+		method:     req.PaymentMethod,
+		authorized: req.Amount,
+		status:     types.PaymentStatusAuthorized,
+	}
+
+	return &Authorization{ProviderRef: ref}, nil
+}
+
+func (m *MockProvider) Capture(ctx context.Context, providerRef string, amount types.Money) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, err := m.getPayment(providerRef, types.PaymentStatusAuthorized)
+	if err != nil {
+		return err
+	}
+
+	if p.method == MockMethodCaptureFail {
+		return fmt.Errorf("capture failed")
+	}
+
+	if cmp, err := amount.Cmp(p.authorized); err != nil || cmp > 0 {
+		return fmt.Errorf("cannot capture more than was authorized")
+	}
+
+	p.captured = amount
+	p.status = types.PaymentStatusCaptured
+	return nil
+}
+
+func (m *MockProvider) Void(ctx context.Context, providerRef string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, err := m.getPayment(providerRef, types.PaymentStatusAuthorized)
+	if err != nil {
+		return err
+	}
+
+	p.status = types.PaymentStatusVoided
+	return nil
+}
+
+func (m *MockProvider) Refund(ctx context.Context, providerRef string, amount types.Money, idempotencyKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.refunds[idempotencyKey] {
+		return nil
+	}
+
+	p, ok := m.payments[providerRef]
+	if !ok {
+		return fmt.Errorf("payment %s not found", providerRef)
+	}
+	if p.status != types.PaymentStatusCaptured && p.status != types.PaymentStatusPartiallyRefunded {
+		return fmt.Errorf("payment %s is %s", providerRef, p.status)
+	}
+
+	refunded, err := p.refunded.Add(amount)
+	if err != nil {
+		return err
+	}
+	cmp, err := refunded.Cmp(p.captured)
+	if err != nil {
+		return err
+	}
+	if cmp > 0 {
+		return fmt.Errorf("cannot refund more than was captured")
+	}
+
+	p.refunded = refunded
+	p.status = types.PaymentStatusPartiallyRefunded
+	if cmp == 0 {
+		p.status = types.PaymentStatusRefunded
+	}
+	m.refunds[idempotencyKey] = true
+
+	return nil
+}
+
+func (m *MockProvider) VerifyWebhook(header http.Header, body []byte) (*WebhookEvent, error) {
+	timestamp, signature, ok := parseMockSignature(header.Get(MockSignatureHeader))
+	if !ok {
+		return nil, ErrInvalidSignature
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, m.sign(timestamp, body)) {
+		return nil, ErrInvalidSignature
+	}
+
+	// old callbacks are rejected so they can't be replayed
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(sent, 0)); age > mockWebhookTolerance || age < -mockWebhookTolerance {
+		return nil, fmt.Errorf("%w: timestamp outside of tolerance", ErrInvalidSignature)
+	}
+
+	event := new(WebhookEvent)
+	if err := json.Unmarshal(body, event); err != nil {
+		return nil, fmt.Errorf("invalid webhook body: %v", err)
+	}
+	if event.ID == "" || event.Type == "" || event.ProviderRef == "" {
+		return nil, fmt.Errorf("invalid webhook body: missing id, type or providerRef")
+	}
+
+	return event, nil
+}
+
+// SignWebhook returns the signature header value for a webhook body, the way
+// the mock gateway would send it.
+func (m *MockProvider) SignWebhook(body []byte, t time.Time) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(m.sign(timestamp, body))
+}
+
+func (m *MockProvider) sign(timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func (m *MockProvider) getPayment(providerRef string, status types.PaymentStatus) (*mockPayment, error) {
+	p, ok := m.payments[providerRef]
+	if !ok {
+		return nil, fmt.Errorf("payment %s not found", providerRef)
+	}
+	if p.status != status {
+		return nil, fmt.Errorf("payment %s is %s", providerRef, p.status)
+	}
+
+	return p, nil
+}
+
+func parseMockSignature(header string) (string, string, bool) {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+
+	return timestamp, signature, timestamp != "" && signature != ""
+}
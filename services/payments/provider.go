@@ -0,0 +1,61 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+const (
+	EventPaymentCaptured = "payment.captured"
+	EventPaymentFailed   = "payment.failed"
+	EventPaymentRefunded = "payment.refunded"
+)
+
+var (
+	ErrPaymentDeclined  = errors.New("payment declined")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrPaymentFailed means no payment was taken for another reason than
+	// the payment method being declined
+	ErrPaymentFailed = errors.New("payment failed")
+)
+
+// PaymentProvider is a payment gateway. Amounts are charged in their own
+// currency; ProviderRef identifies a payment with the provider.
+type PaymentProvider interface {
+	Name() string
+	// Authorize reserves the amount on the customer's payment method. A
+	// declined payment returns an error wrapping ErrPaymentDeclined.
+	Authorize(ctx context.Context, req AuthorizeRequest) (*Authorization, error)
+	// Capture collects an authorized amount, at most what was authorized.
+	Capture(ctx context.Context, providerRef string, amount types.Money) error
+	// Void releases an authorization that hasn't been captured.
+	Void(ctx context.Context, providerRef string) error
+	// Refund gives back part or all of a captured amount. A refund retried
+	// with the same idempotency key is only made once.
+	Refund(ctx context.Context, providerRef string, amount types.Money, idempotencyKey string) error
+	// VerifyWebhook checks the signature of a webhook callback and decodes
+	// its event. A bad signature returns ErrInvalidSignature.
+	VerifyWebhook(header http.Header, body []byte) (*WebhookEvent, error)
+}
+
+type AuthorizeRequest struct {
+	OrderID       int
+	Amount        types.Money
+	PaymentMethod string
+}
+
+type Authorization struct {
+	ProviderRef string
+}
+
+// WebhookEvent is a payment update sent by a provider. Amount is the total
+// captured or refunded so far, depending on the event type.
+type WebhookEvent struct {
+	ID          string      `json:"id"`
+	Type        string      `json:"type"`
+	ProviderRef string      `json:"providerRef"`
+	Amount      types.Money `json:"amount"`
+}
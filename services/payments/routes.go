@@ -0,0 +1,61 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/duziem/ecommerce_proj/utils"
+	"github.com/gorilla/mux"
+)
+
+const maxWebhookBodySize = 1 << 20
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// receive a signed event from a payment provider
+	router.HandleFunc("/payments/webhook/{provider}", h.handleWebhook).Methods(http.MethodPost)
+}
+
+func (h *Handler) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.service.Provider(mux.Vars(r)["provider"])
+	if !ok {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("unknown payment provider"))
+		return
+	}
+
+	// the signature covers the raw body, so it has to be read as is
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("failed to read request body"))
+		return
+	}
+
+	event, err := provider.VerifyWebhook(r.Header, body)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.service.HandleWebhookEvent(r.Context(), provider.Name(), event)
+	if errors.Is(err, ErrUnknownPayment) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to handle %s webhook event %s: %v", provider.Name(), event.ID, err)
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"received": true})
+}
@@ -0,0 +1,380 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/duziem/ecommerce_proj/services/order"
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+var (
+	ErrUnknownPayment = errors.New("unknown payment")
+	// ErrOrderCancelled means the order was cancelled before its payment
+	// was captured, so the payment was refunded
+	ErrOrderCancelled = errors.New("order was cancelled before the payment went through, the payment has been refunded")
+)
+
+// Service takes payments for orders through the configured provider, and
+// applies the updates providers send back through webhooks.
+type Service struct {
+	store           types.PaymentStore
+	orderStore      types.OrderStore
+	productStore    types.ProductStore
+	providers       map[string]PaymentProvider
+	defaultProvider string
+}
+
+func NewService(
+	store types.PaymentStore,
+	orderStore types.OrderStore,
+	productStore types.ProductStore,
+	defaultProvider string,
+	providers ...PaymentProvider,
+) *Service {
+	s := &Service{
+		store:           store,
+		orderStore:      orderStore,
+		productStore:    productStore,
+		providers:       make(map[string]PaymentProvider),
+		defaultProvider: defaultProvider,
+	}
+
+	for _, p := range providers {
+		s.providers[p.Name()] = p
+	}
+
+	return s
+}
+
+func (s *Service) Provider(name string) (PaymentProvider, bool) {
+	p, ok := s.providers[name]
+	return p, ok
+}
+
+// PayOrder authorizes and captures the order's total and marks the order
+// paid. When no payment was taken the order is cancelled, which puts its
+// items back in stock, and an error wrapping ErrPaymentDeclined or
+// ErrPaymentFailed is returned. Once the payment has been captured the order
+// is never cancelled, other errors leave it pending for the provider's
+// capture webhook to mark paid. An order that was cancelled in the meantime
+// gets its payment refunded and ErrOrderCancelled is returned.
+func (s *Service) PayOrder(ctx context.Context, o *types.Order, paymentMethod string) error {
+	// nothing to collect, e.g. when a promotion covers the whole order
+	if o.Total.IsZero() {
+		return s.markPaid(ctx, o.ID, nil)
+	}
+
+	provider, ok := s.providers[s.defaultProvider]
+	if !ok {
+		return s.cancelUnpaid(o.ID, fmt.Errorf("payment provider %s is not configured", s.defaultProvider))
+	}
+
+	intent := types.PaymentIntent{
+		OrderID:        o.ID,
+		Provider:       provider.Name(),
+		Status:         types.PaymentStatusCreated,
+		Amount:         o.Total,
+		RefundedAmount: types.NewMoney(0, o.Total.Currency),
+	}
+
+	id, err := s.store.CreatePaymentIntent(intent)
+	if err != nil {
+		return s.cancelUnpaid(o.ID, err)
+	}
+	intent.ID = id
+
+	captured, err := s.authorizeAndCapture(ctx, provider, &intent, paymentMethod)
+	if !captured {
+		if intent.Status != types.PaymentStatusVoided {
+			intent.Status = types.PaymentStatusFailed
+		}
+		intent.LastError = err.Error()
+		if uerr := s.store.UpdatePaymentIntent(intent); uerr != nil {
+			log.Printf("failed to update payment intent %d: %v", intent.ID, uerr)
+		}
+
+		return s.cancelUnpaid(o.ID, err)
+	}
+	if err != nil {
+		// the customer has paid, so the order goes ahead and the capture
+		// webhook brings the intent up to date
+		log.Printf("failed to record the capture of payment intent %d: %v", intent.ID, err)
+	}
+
+	return s.markPaid(ctx, o.ID, &intent)
+}
+
+// authorizeAndCapture takes the payment, reporting whether it was captured.
+// An error with captured set means only recording the capture failed.
+func (s *Service) authorizeAndCapture(ctx context.Context, provider PaymentProvider, intent *types.PaymentIntent, paymentMethod string) (bool, error) {
+	auth, err := provider.Authorize(ctx, AuthorizeRequest{
+		OrderID:       intent.OrderID,
+		Amount:        intent.Amount,
+		PaymentMethod: paymentMethod,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	intent.ProviderRef = auth.ProviderRef
+	intent.Status = types.PaymentStatusAuthorized
+	if err := s.store.UpdatePaymentIntent(*intent); err != nil {
+		// a payment that isn't recorded can't be matched to its webhooks
+		s.void(ctx, provider, intent)
+		return false, err
+	}
+
+	if err := provider.Capture(ctx, intent.ProviderRef, intent.Amount); err != nil {
+		s.void(ctx, provider, intent)
+		return false, fmt.Errorf("failed to capture payment: %w", err)
+	}
+
+	intent.Status = types.PaymentStatusCaptured
+	return true, s.store.UpdatePaymentIntent(*intent)
+}
+
+// void releases the hold on the customer's funds.
+func (s *Service) void(ctx context.Context, provider PaymentProvider, intent *types.PaymentIntent) {
+	if err := provider.Void(ctx, intent.ProviderRef); err != nil {
+		log.Printf("failed to void payment %s: %v", intent.ProviderRef, err)
+		return
+	}
+
+	intent.Status = types.PaymentStatusVoided
+}
+
+// cancelUnpaid cancels an order no payment was taken for.
+func (s *Service) cancelUnpaid(orderID int, err error) error {
+	if cerr := order.CancelOrder(s.orderStore, s.productStore, orderID, 0); cerr != nil {
+		log.Printf("failed to cancel unpaid order %d: %v", orderID, cerr)
+	}
+
+	if errors.Is(err, ErrPaymentDeclined) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %v", ErrPaymentFailed, err)
+}
+
+// CancelOrder cancels the order on behalf of an admin, putting its items back
+// in stock, and refunds what was captured for it. The refund is made once per
+// order, so one that failed is retried by cancelling the order again.
+func (s *Service) CancelOrder(ctx context.Context, orderID int) error {
+	cancelErr := order.CancelOrder(s.orderStore, s.productStore, orderID, 0)
+	if cancelErr != nil && !errors.Is(cancelErr, order.ErrOrderNotCancellable) {
+		return cancelErr
+	}
+	if cancelErr != nil {
+		// only a cancelled order can still have a refund to finish
+		o, err := s.orderStore.GetOrderByID(orderID)
+		if err != nil || o.Status != types.OrderStatusCancelled {
+			return cancelErr
+		}
+	}
+
+	tx, err := s.productStore.BeginTransaction()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	intent, err := s.store.GetPaymentIntentByOrderIDWithLock(tx, orderID)
+	if err == sql.ErrNoRows {
+		return cancelErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// nothing was captured, or it has all been given back
+	if intent.Status != types.PaymentStatusCaptured && intent.Status != types.PaymentStatusPartiallyRefunded {
+		return cancelErr
+	}
+
+	remaining, err := intent.Amount.Sub(intent.RefundedAmount)
+	if err != nil {
+		return err
+	}
+
+	// the same key as markPaid uses, so a capture webhook racing the
+	// cancellation doesn't refund the order twice
+	key := fmt.Sprintf("cancelled-order-%d", orderID)
+	if err := s.Refund(ctx, tx, orderID, remaining, key); err != nil {
+		return fmt.Errorf("order %d was cancelled but its payment was not refunded: %w", orderID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// Refund gives back part of what was captured for the order, recording it
+// on the payment in tx. The provider makes the refund once per idempotency
+// key, so a refund whose transaction failed can be retried with the same
+// key. Orders that weren't paid through a provider have nothing to refund
+// there.
+func (s *Service) Refund(ctx context.Context, tx *sql.Tx, orderID int, amount types.Money, idempotencyKey string) error {
+	if amount.IsZero() {
+		return nil
+	}
+
+	intent, err := s.store.GetPaymentIntentByOrderIDWithLock(tx, orderID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if intent.Status != types.PaymentStatusCaptured && intent.Status != types.PaymentStatusPartiallyRefunded {
+		return fmt.Errorf("cannot refund a %s payment", intent.Status)
+	}
+
+	provider, ok := s.providers[intent.Provider]
+	if !ok {
+		return fmt.Errorf("payment provider %s is not configured", intent.Provider)
+	}
+
+	refunded, err := intent.RefundedAmount.Add(amount)
+	if err != nil {
+		return err
+	}
+
+	if err := provider.Refund(ctx, intent.ProviderRef, amount, idempotencyKey); err != nil {
+		return fmt.Errorf("failed to refund payment: %w", err)
+	}
+
+	if err := setRefunded(intent, refunded); err != nil {
+		return err
+	}
+
+	return s.store.UpdatePaymentIntentTx(tx, *intent)
+}
+
+// HandleWebhookEvent applies a provider's update to the payment it is about.
+// Events are safe to deliver more than once.
+func (s *Service) HandleWebhookEvent(ctx context.Context, provider string, event *WebhookEvent) error {
+	intent, err := s.store.GetPaymentIntentByProviderRef(provider, event.ProviderRef)
+	if err == sql.ErrNoRows {
+		return ErrUnknownPayment
+	}
+	if err != nil {
+		return err
+	}
+
+	switch event.Type {
+	case EventPaymentCaptured:
+		if intent.Status != types.PaymentStatusCreated && intent.Status != types.PaymentStatusAuthorized {
+			return nil
+		}
+
+		// the order is marked paid first so a failure leaves the event to be
+		// delivered again
+		intent.Status = types.PaymentStatusCaptured
+		err := s.markPaid(ctx, intent.OrderID, intent)
+		if errors.Is(err, ErrOrderCancelled) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return s.store.UpdatePaymentIntent(*intent)
+
+	case EventPaymentFailed:
+		if intent.Status != types.PaymentStatusCreated && intent.Status != types.PaymentStatusAuthorized {
+			return nil
+		}
+
+		intent.Status = types.PaymentStatusFailed
+		if err := s.store.UpdatePaymentIntent(*intent); err != nil {
+			return err
+		}
+
+		err := order.CancelOrder(s.orderStore, s.productStore, intent.OrderID, 0)
+		if errors.Is(err, order.ErrOrderNotCancellable) {
+			return nil
+		}
+		return err
+
+	case EventPaymentRefunded:
+		if cmp, err := event.Amount.Cmp(intent.RefundedAmount); err != nil || cmp <= 0 {
+			return err
+		}
+
+		if err := setRefunded(intent, event.Amount); err != nil {
+			return err
+		}
+
+		return s.store.UpdatePaymentIntent(*intent)
+	}
+
+	// other events aren't of interest
+	return nil
+}
+
+func setRefunded(intent *types.PaymentIntent, refunded types.Money) error {
+	cmp, err := refunded.Cmp(intent.Amount)
+	if err != nil {
+		return err
+	}
+
+	intent.RefundedAmount = refunded
+	intent.Status = types.PaymentStatusPartiallyRefunded
+	if cmp >= 0 {
+		intent.Status = types.PaymentStatusRefunded
+	}
+
+	return nil
+}
+
+// markPaid moves a pending order to paid, which a webhook may already have
+// done. The captured intent of an order that was cancelled in the meantime,
+// e.g. because its reservation expired before the payment went through, is
+// refunded in full and ErrOrderCancelled is returned.
+func (s *Service) markPaid(ctx context.Context, orderID int, intent *types.PaymentIntent) error {
+	err := order.MarkOrderPaid(s.orderStore, s.productStore, orderID)
+	if !errors.Is(err, order.ErrOrderNotPayable) {
+		return err
+	}
+
+	o, err := s.orderStore.GetOrderByID(orderID)
+	if err != nil {
+		return err
+	}
+
+	// only a cancelled order hasn't been paid for already
+	if o.Status != types.OrderStatusCancelled {
+		return nil
+	}
+
+	if intent == nil {
+		return ErrOrderCancelled
+	}
+
+	provider, ok := s.providers[intent.Provider]
+	if !ok {
+		return fmt.Errorf("payment provider %s is not configured", intent.Provider)
+	}
+
+	key := fmt.Sprintf("cancelled-order-%d", orderID)
+	if err := provider.Refund(ctx, intent.ProviderRef, intent.Amount, key); err != nil {
+		return fmt.Errorf("failed to refund the payment of cancelled order %d: %w", orderID, err)
+	}
+
+	if err := setRefunded(intent, intent.Amount); err != nil {
+		return err
+	}
+	// the refund is made once per order, so a failure here can be retried
+	if err := s.store.UpdatePaymentIntent(*intent); err != nil {
+		return err
+	}
+
+	return ErrOrderCancelled
+}
@@ -0,0 +1,120 @@
+package payments
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+// paymentIntentColumns lists the columns read by scanRowsIntoPaymentIntent, in scan order.
+const paymentIntentColumns = "id, orderId, provider, COALESCE(providerRef, ''), status, amount, refundedAmount, lastError, createdAt, updatedAt"
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) CreatePaymentIntent(intent types.PaymentIntent) (int, error) {
+	var id int
+	err := s.db.QueryRow("INSERT INTO payment_intents (orderId, provider, status, amount) VALUES ($1, $2, $3, $4) RETURNING id",
+		intent.OrderID, intent.Provider, intent.Status, intent.Amount).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create payment intent: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetPaymentIntentByOrderID returns the order's latest payment intent.
+func (s *Store) GetPaymentIntentByOrderID(orderID int) (*types.PaymentIntent, error) {
+	return getPaymentIntent(s.db, "SELECT "+paymentIntentColumns+" FROM payment_intents WHERE orderId = $1 ORDER BY id DESC LIMIT 1", orderID)
+}
+
+// GetPaymentIntentByOrderIDWithLock locks the order's latest payment intent
+// until the transaction ends.
+func (s *Store) GetPaymentIntentByOrderIDWithLock(tx *sql.Tx, orderID int) (*types.PaymentIntent, error) {
+	return getPaymentIntent(tx, "SELECT "+paymentIntentColumns+" FROM payment_intents WHERE orderId = $1 ORDER BY id DESC LIMIT 1 FOR UPDATE", orderID)
+}
+
+func (s *Store) GetPaymentIntentByProviderRef(provider, providerRef string) (*types.PaymentIntent, error) {
+	return getPaymentIntent(s.db, "SELECT "+paymentIntentColumns+" FROM payment_intents WHERE provider = $1 AND providerRef = $2", provider, providerRef)
+}
+
+func (s *Store) UpdatePaymentIntent(intent types.PaymentIntent) error {
+	return updatePaymentIntent(s.db, intent)
+}
+
+func (s *Store) UpdatePaymentIntentTx(tx *sql.Tx, intent types.PaymentIntent) error {
+	return updatePaymentIntent(tx, intent)
+}
+
+func updatePaymentIntent(q querier, intent types.PaymentIntent) error {
+	_, err := q.Exec(`
+			UPDATE payment_intents
+			SET providerRef = NULLIF($1, ''), status = $2, refundedAmount = $3, lastError = $4, updatedAt = NOW()
+			WHERE id = $5`,
+		intent.ProviderRef, intent.Status, intent.RefundedAmount, intent.LastError, intent.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update payment intent: %w", err)
+	}
+
+	return nil
+}
+
+func getPaymentIntent(q querier, query string, args ...interface{}) (*types.PaymentIntent, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	intent := new(types.PaymentIntent)
+	for rows.Next() {
+		intent, err = scanRowsIntoPaymentIntent(rows)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// callers tell a missing intent from a failed query by sql.ErrNoRows
+	if intent.ID == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return intent, nil
+}
+
+func scanRowsIntoPaymentIntent(rows *sql.Rows) (*types.PaymentIntent, error) {
+	intent := new(types.PaymentIntent)
+
+	err := rows.Scan(
+		&intent.ID,
+		&intent.OrderID,
+		&intent.Provider,
+		&intent.ProviderRef,
+		&intent.Status,
+		&intent.Amount,
+		&intent.RefundedAmount,
+		&intent.LastError,
+		&intent.CreatedAt,
+		&intent.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return intent, nil
+}
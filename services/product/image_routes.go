@@ -0,0 +1,311 @@
+package product
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/duziem/ecommerce_proj/configs"
+	"github.com/duziem/ecommerce_proj/services/media"
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/duziem/ecommerce_proj/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+// multipartOverhead is allowed on top of the image size for the rest of the form
+const multipartOverhead = 1 << 20
+
+func (h *Handler) handleGetImages(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	product, err := h.store.GetProductByID(productID)
+	if err != nil || product.DeletedAt != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	images, err := h.getImages(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, images)
+}
+
+func (h *Handler) handleUploadImage(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// archived products keep their images as they are
+	if product, err := h.store.GetProductByID(productID); err != nil || product.DeletedAt != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	maxSize := configs.Envs.MaxImageSizeInBytes
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+
+	file, header, err := r.FormFile("image")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		utils.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("image must be at most %d bytes", maxSize))
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("missing image: %v", err))
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxSize {
+		utils.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("image must be at most %d bytes", maxSize))
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	img, err := media.DecodeImage(data)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnsupportedMediaType, err)
+		return
+	}
+
+	image, err := h.storeImage(r.Context(), productID, data, img)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	created, err := h.store.CreateProductImage(*image)
+	if err != nil {
+		h.deleteImageFiles(r.Context(), *image)
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.syncMainImage(productID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.setImageURLs(created)
+	utils.WriteJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handler) handleReorderImages(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.ReorderProductImagesPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	// archived products keep their images as they are
+	if product, err := h.store.GetProductByID(productID); err != nil || product.DeletedAt != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	if err := h.store.ReorderProductImages(productID, payload.ImageIDs); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.syncMainImage(productID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	images, err := h.getImages(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, images)
+}
+
+func (h *Handler) handleDeleteImage(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	imageID, err := strconv.Atoi(mux.Vars(r)["imageID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid image ID"))
+		return
+	}
+
+	// archived products keep their images as they are
+	if product, err := h.store.GetProductByID(productID); err != nil || product.DeletedAt != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	images, err := h.store.GetProductImages(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var image *types.ProductImage
+	for i := range images {
+		if images[i].ID == imageID {
+			image = &images[i]
+		}
+	}
+	if image == nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("image not found"))
+		return
+	}
+
+	if err := h.store.DeleteProductImage(*image); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// the files go once nothing points at them anymore, which includes the
+	// orders that were placed while it was the main image
+	ordered, err := h.store.IsImageInOrders(h.storage.URL(image.Key))
+	if err != nil {
+		log.Printf("failed to check the orders of image %d, keeping its files: %v", image.ID, err)
+	} else if !ordered {
+		h.deleteImageFiles(r.Context(), *image)
+	}
+
+	if err := h.syncMainImage(productID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "image deleted successfully"})
+}
+
+// storeImage saves the upload and its thumbnails under a new directory of
+// the product, so a key never points at different content.
+func (h *Handler) storeImage(ctx context.Context, productID int, data []byte, img *media.Image) (*types.ProductImage, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	dir := fmt.Sprintf("products/%d/%s/", productID, hex.EncodeToString(b))
+
+	bounds := img.Bounds()
+	image := &types.ProductImage{
+		ProductID:     productID,
+		Key:           dir + "original." + img.Extension,
+		ThumbnailKeys: make(map[string]string),
+		ContentType:   img.ContentType,
+		Width:         bounds.Dx(),
+		Height:        bounds.Dy(),
+		Size:          int64(len(data)),
+	}
+
+	if err := h.storage.Put(ctx, image.Key, bytes.NewReader(data), img.ContentType); err != nil {
+		return nil, err
+	}
+
+	for _, size := range media.ThumbnailSizes {
+		var buf bytes.Buffer
+		contentType, ext, err := img.EncodeThumbnail(&buf, img.Thumbnail(size.Max))
+		if err != nil {
+			h.deleteImageFiles(ctx, *image)
+			return nil, fmt.Errorf("failed to create %s thumbnail: %w", size.Name, err)
+		}
+
+		key := dir + size.Name + "." + ext
+		if err := h.storage.Put(ctx, key, &buf, contentType); err != nil {
+			h.deleteImageFiles(ctx, *image)
+			return nil, err
+		}
+		image.ThumbnailKeys[size.Name] = key
+	}
+
+	return image, nil
+}
+
+// deleteImageFiles removes the files of an image. Failures only leave
+// unreferenced files behind, so they are logged rather than returned.
+func (h *Handler) deleteImageFiles(ctx context.Context, image types.ProductImage) {
+	keys := []string{image.Key}
+	for _, key := range image.ThumbnailKeys {
+		keys = append(keys, key)
+	}
+
+	for _, key := range keys {
+		if err := h.storage.Delete(ctx, key); err != nil {
+			log.Printf("failed to delete %s: %v", key, err)
+		}
+	}
+}
+
+// getImages returns the images of a product with the URLs they are served from.
+func (h *Handler) getImages(productID int) ([]types.ProductImage, error) {
+	images, err := h.store.GetProductImages(productID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range images {
+		h.setImageURLs(&images[i])
+	}
+
+	return images, nil
+}
+
+func (h *Handler) setImageURLs(image *types.ProductImage) {
+	image.URL = h.storage.URL(image.Key)
+	image.Thumbnails = make(map[string]string, len(image.ThumbnailKeys))
+	for name, key := range image.ThumbnailKeys {
+		image.Thumbnails[name] = h.storage.URL(key)
+	}
+}
+
+// syncMainImage keeps products.image pointing at the first image, for the
+// cart and order views that only show one.
+func (h *Handler) syncMainImage(productID int) error {
+	images, err := h.store.GetProductImages(productID)
+	if err != nil {
+		return err
+	}
+
+	mainImage := ""
+	if len(images) > 0 {
+		mainImage = h.storage.URL(images[0].Key)
+	}
+
+	return h.store.SetProductImage(productID, mainImage)
+}
@@ -0,0 +1,201 @@
+package product
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+const imageColumns = "id, productId, position, storageKey, thumbnailKeys, contentType, width, height, size, createdAt"
+
+func (s *Store) GetProductImages(productID int) ([]types.ProductImage, error) {
+	rows, err := s.db.Query("SELECT "+imageColumns+" FROM product_images WHERE productId = $1 ORDER BY position", productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []types.ProductImage{}
+	for rows.Next() {
+		img, err := scanRowsIntoProductImage(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		images = append(images, *img)
+	}
+
+	return images, rows.Err()
+}
+
+// CreateProductImage adds the image after the product's other images.
+func (s *Store) CreateProductImage(img types.ProductImage) (*types.ProductImage, error) {
+	thumbnailKeys, err := json.Marshal(img.ThumbnailKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockProduct(tx, img.ProductID); err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO product_images (productId, position, storageKey, thumbnailKeys, contentType, width, height, size)
+		SELECT $1, COALESCE(MAX(position) + 1, 0), $2, $3, $4, $5, $6, $7 FROM product_images WHERE productId = $1
+		RETURNING id, position, createdAt`,
+		img.ProductID, img.Key, thumbnailKeys, img.ContentType, img.Width, img.Height, img.Size,
+	).Scan(&img.ID, &img.Position, &img.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &img, nil
+}
+
+// DeleteProductImage removes the image and closes the gap it leaves in the
+// product's positions.
+func (s *Store) DeleteProductImage(img types.ProductImage) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockProduct(tx, img.ProductID); err != nil {
+		return err
+	}
+
+	var position int
+	err = tx.QueryRow("DELETE FROM product_images WHERE id = $1 AND productId = $2 RETURNING position", img.ID, img.ProductID).Scan(&position)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("image not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE product_images SET position = position - 1 WHERE productId = $1 AND position > $2", img.ProductID, position)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReorderProductImages puts the product's images in the order of imageIDs,
+// which has to list each of them exactly once.
+func (s *Store) ReorderProductImages(productID int, imageIDs []int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockProduct(tx, productID); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT id FROM product_images WHERE productId = $1", productID)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		current[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(imageIDs) != len(current) {
+		return fmt.Errorf("expected the %d images of product %d, got %d", len(current), productID, len(imageIDs))
+	}
+
+	seen := make(map[int]bool)
+	for _, id := range imageIDs {
+		if !current[id] || seen[id] {
+			return fmt.Errorf("image %d is not an image of product %d or is listed twice", id, productID)
+		}
+		seen[id] = true
+	}
+
+	// the unique position constraint is deferred, so positions can be swapped
+	for position, id := range imageIDs {
+		if _, err := tx.Exec("UPDATE product_images SET position = $1 WHERE id = $2", position, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetProductImage sets the main image of the product.
+func (s *Store) SetProductImage(productID int, image string) error {
+	_, err := s.db.Exec("UPDATE products SET image = $1 WHERE id = $2", image, productID)
+	return err
+}
+
+// IsImageInOrders reports whether an order item still shows the image, as
+// order items keep the URL of the product's main image at checkout.
+func (s *Store) IsImageInOrders(url string) (bool, error) {
+	var ordered bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM order_items WHERE productImage = $1)", url).Scan(&ordered)
+	return ordered, err
+}
+
+// lockProduct serializes changes to the images of a product.
+func lockProduct(tx *sql.Tx, productID int) error {
+	var id int
+	err := tx.QueryRow("SELECT id FROM products WHERE id = $1 FOR UPDATE", productID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("product not found")
+	}
+
+	return err
+}
+
+func scanRowsIntoProductImage(rows *sql.Rows) (*types.ProductImage, error) {
+	img := new(types.ProductImage)
+	var thumbnailKeys []byte
+
+	err := rows.Scan(
+		&img.ID,
+		&img.ProductID,
+		&img.Position,
+		&img.Key,
+		&thumbnailKeys,
+		&img.ContentType,
+		&img.Width,
+		&img.Height,
+		&img.Size,
+		&img.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(thumbnailKeys, &img.ThumbnailKeys); err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
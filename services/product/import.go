@@ -0,0 +1,262 @@
+package product
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/duziem/ecommerce_proj/utils"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	importFormatCSV    = "csv"
+	importFormatNDJSON = "ndjson"
+
+	defaultImportChunkSize = 500
+	maxImportChunkSize     = 5000
+	maxImportLineSize      = 1 << 20
+)
+
+// importColumns are the CSV columns, name and price are required
+var importColumns = []string{"sku", "name", "description", "image", "price", "quantity"}
+
+var (
+	// errInvalidRow marks a row that couldn't be read but doesn't stop the import
+	errInvalidRow = errors.New("invalid row")
+	// errInvalidImport marks an upload that can't be read any further
+	errInvalidImport = errors.New("invalid import")
+)
+
+type importOptions struct {
+	DryRun bool
+	// Atomic imports write every row or none, other imports commit every
+	// ChunkSize rows and skip the rows that fail
+	Atomic    bool
+	ChunkSize int
+}
+
+// productRowReader reads import rows one at a time, so files of any size can
+// be streamed. Next returns io.EOF after the last row, and an error wrapping
+// errInvalidRow for a row that can't be read.
+type productRowReader interface {
+	Next() (types.CreateProductPayload, error)
+}
+
+func newProductRowReader(format string, r io.Reader) (productRowReader, error) {
+	switch format {
+	case importFormatCSV:
+		return newCSVRowReader(r)
+	case importFormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineSize)
+		return &ndjsonRowReader{scanner: scanner}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported format %q, use csv or ndjson", format)
+}
+
+type csvRowReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVRowReader(r io.Reader) (*csvRowReader, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("missing csv header")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid csv header: %v", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(importColumns, name) {
+			return nil, fmt.Errorf("unknown csv column %q, expected %s", name, strings.Join(importColumns, ", "))
+		}
+		columns[name] = i
+	}
+
+	for _, required := range []string{"name", "price"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing csv column %q", required)
+		}
+	}
+
+	return &csvRowReader{reader: reader, columns: columns}, nil
+}
+
+func (c *csvRowReader) Next() (types.CreateProductPayload, error) {
+	var row types.CreateProductPayload
+
+	record, err := c.reader.Read()
+	if err == io.EOF {
+		return row, err
+	}
+	if parseErr, ok := err.(*csv.ParseError); ok && errors.Is(parseErr.Err, csv.ErrFieldCount) {
+		return row, fmt.Errorf("%w: %v", errInvalidRow, parseErr.Err)
+	}
+	if err != nil {
+		return row, err
+	}
+
+	field := func(name string) string {
+		if i, ok := c.columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	row.SKU = field("sku")
+	row.Name = field("name")
+	row.Description = field("description")
+	row.Image = field("image")
+
+	if row.Price, err = types.ParseMoney(field("price"), ""); err != nil {
+		return row, fmt.Errorf("%w: price: %v", errInvalidRow, err)
+	}
+
+	if str := field("quantity"); str != "" {
+		if row.Quantity, err = strconv.Atoi(str); err != nil {
+			return row, fmt.Errorf("%w: invalid quantity %q", errInvalidRow, str)
+		}
+	}
+
+	return row, nil
+}
+
+type ndjsonRowReader struct {
+	scanner *bufio.Scanner
+}
+
+func (n *ndjsonRowReader) Next() (types.CreateProductPayload, error) {
+	var row types.CreateProductPayload
+
+	for n.scanner.Scan() {
+		line := strings.TrimSpace(n.scanner.Text())
+		// blank lines aren't rows
+		if line == "" {
+			continue
+		}
+
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&row); err != nil {
+			return row, fmt.Errorf("%w: %v", errInvalidRow, err)
+		}
+
+		return row, nil
+	}
+
+	if err := n.scanner.Err(); err != nil {
+		return row, err
+	}
+
+	return row, io.EOF
+}
+
+// validateImportRow applies the rules of a created product to an import row.
+func validateImportRow(row types.CreateProductPayload) error {
+	if err := utils.Validate.Struct(row); err != nil {
+		errors := err.(validator.ValidationErrors)
+		return fmt.Errorf("invalid row: %v", errors)
+	}
+
+	return validatePriceCurrency(&row.Price)
+}
+
+// runImport writes the rows to the store as opts describe, and reports on
+// every row. When an import that committed chunks stops with an error, the
+// report of what was committed is returned along with the error.
+func runImport(store types.ProductStore, rows productRowReader, opts importOptions) (*types.ImportReport, error) {
+	report := &types.ImportReport{DryRun: opts.DryRun, Atomic: opts.Atomic, Errors: []types.ImportRowError{}}
+
+	stop := func(err error) (*types.ImportReport, error) {
+		if report.CommittedRows == 0 {
+			return nil, err
+		}
+
+		report.Error = fmt.Sprintf("%v, rows 1 to %d were already committed", err, report.CommittedRows)
+		return report, err
+	}
+
+	tx, err := store.BeginTransaction()
+	if err != nil {
+		return nil, err
+	}
+	// tx is replaced after every chunk
+	defer func() { tx.Rollback() }()
+
+	inChunk := 0
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		report.Rows++
+
+		if err == nil {
+			err = validateImportRow(row)
+		} else if !errors.Is(err, errInvalidRow) {
+			return stop(fmt.Errorf("%w: failed to read row %d: %v", errInvalidImport, report.Rows, err))
+		}
+
+		var created bool
+		if err == nil {
+			created, err = store.ImportProduct(tx, row)
+		}
+
+		if err != nil {
+			report.Failed++
+			report.Errors = append(report.Errors, types.ImportRowError{Row: report.Rows, SKU: row.SKU, Name: row.Name, Error: err.Error()})
+			continue
+		}
+
+		if created {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+
+		inChunk++
+		if !opts.Atomic && !opts.DryRun && inChunk == opts.ChunkSize {
+			if err := tx.Commit(); err != nil {
+				return stop(fmt.Errorf("failed to commit rows up to %d: %v", report.Rows, err))
+			}
+			report.CommittedRows = report.Rows
+
+			// tx is only replaced once the next one has begun, the deferred
+			// rollback can't be left with a nil tx
+			next, err := store.BeginTransaction()
+			if err != nil {
+				return stop(err)
+			}
+			tx = next
+			inChunk = 0
+		}
+	}
+
+	// dry runs and failed atomic imports are rolled back
+	if opts.DryRun || (opts.Atomic && report.Failed > 0) {
+		return report, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return stop(fmt.Errorf("failed to commit transaction: %v", err))
+	}
+	report.CommittedRows = report.Rows
+
+	return report, nil
+}
@@ -0,0 +1,171 @@
+package product
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/duziem/ecommerce_proj/utils"
+)
+
+const (
+	maxImportSize = 100 << 20
+	// exportFlushEvery rows are sent to the client at a time
+	exportFlushEvery = 100
+)
+
+func (h *Handler) handleImportProducts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = formatFromContentType(r.Header.Get("Content-Type"))
+	}
+
+	opts := importOptions{Atomic: true, ChunkSize: defaultImportChunkSize}
+
+	if str := query.Get("dryRun"); str != "" {
+		dryRun, err := strconv.ParseBool(str)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid dryRun"))
+			return
+		}
+		opts.DryRun = dryRun
+	}
+
+	switch query.Get("mode") {
+	case "", "atomic":
+	case "chunked":
+		opts.Atomic = false
+	default:
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid mode, use atomic or chunked"))
+		return
+	}
+
+	if str := query.Get("chunkSize"); str != "" {
+		size, err := strconv.Atoi(str)
+		if err != nil || size <= 0 {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid chunkSize"))
+			return
+		}
+		opts.ChunkSize = min(size, maxImportChunkSize)
+	}
+
+	rows, err := newProductRowReader(format, http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	report, err := runImport(h.store, rows, opts)
+	if err != nil && report != nil {
+		// earlier chunks were committed, the report says which rows made it
+		status := http.StatusInternalServerError
+		if errors.Is(err, errInvalidImport) {
+			status = http.StatusBadRequest
+		}
+		utils.WriteJSON(w, status, report)
+		return
+	}
+	if errors.Is(err, errInvalidImport) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// an atomic import with failed rows wrote nothing
+	if opts.Atomic && !opts.DryRun && report.Failed > 0 {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, report)
+}
+
+func (h *Handler) handleExportProducts(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = importFormatCSV
+	}
+
+	var write func(types.CreateProductPayload) error
+	var flush func() error
+
+	switch format {
+	case importFormatCSV:
+		writer := csv.NewWriter(w)
+		w.Header().Set("Content-Type", "text/csv")
+		write = func(p types.CreateProductPayload) error {
+			return writer.Write([]string{p.SKU, p.Name, p.Description, p.Image, p.Price.String(), strconv.Itoa(p.Quantity)})
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+
+		if err := writer.Write(importColumns); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	case importFormatNDJSON:
+		encoder := json.NewEncoder(w)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		write = func(p types.CreateProductPayload) error {
+			return encoder.Encode(p)
+		}
+		flush = func() error { return nil }
+	default:
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("unsupported format %q, use csv or ndjson", format))
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="products.%s"`, format))
+
+	// rows are streamed as they are read, so errors can only be logged
+	written := 0
+	err := h.store.ExportProducts(func(p types.CreateProductPayload) error {
+		if err := write(p); err != nil {
+			return err
+		}
+
+		written++
+		if written%exportFlushEvery == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		log.Printf("product export failed after %d rows: %v", written, err)
+	}
+}
+
+func formatFromContentType(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch mediaType {
+	case "text/csv":
+		return importFormatCSV
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return importFormatNDJSON
+	}
+
+	return ""
+}
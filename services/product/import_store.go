@@ -0,0 +1,161 @@
+package product
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+// ImportProduct creates or updates the product described by an import row.
+// A row matches the variant with its SKU, or the product with its name when
+// it has no SKU. A failed row is rolled back on its own, leaving the
+// transaction usable for the next one.
+func (s *Store) ImportProduct(tx *sql.Tx, product types.CreateProductPayload) (bool, error) {
+	if _, err := tx.Exec("SAVEPOINT import_product"); err != nil {
+		return false, err
+	}
+
+	created, err := importProduct(tx, product)
+	if err != nil {
+		if _, rerr := tx.Exec("ROLLBACK TO SAVEPOINT import_product"); rerr != nil {
+			return false, rerr
+		}
+		return false, err
+	}
+
+	if _, err := tx.Exec("RELEASE SAVEPOINT import_product"); err != nil {
+		return false, err
+	}
+
+	return created, nil
+}
+
+func importProduct(tx *sql.Tx, product types.CreateProductPayload) (bool, error) {
+	productID, variantID, err := findImportedVariant(tx, product)
+	if err != nil {
+		return false, err
+	}
+
+	if productID == 0 {
+		_, err := insertProduct(tx, product, types.InventoryReasonImport)
+		return true, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE products
+		SET name = $1,
+		    price = $2,
+		    image = COALESCE(NULLIF($3, ''), image),
+		    description = COALESCE(NULLIF($4, ''), description)
+		WHERE id = $5`,
+		product.Name, product.Price, product.Image, product.Description, productID)
+	if err != nil {
+		return false, err
+	}
+
+	quantity, err := lockVariantQuantity(tx, variantID)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec("UPDATE product_variants SET quantity = $1 WHERE id = $2", product.Quantity, variantID); err != nil {
+		return false, err
+	}
+
+	if err := recordStockChange(tx, productID, variantID, product.Quantity-quantity, types.InventoryReasonImport); err != nil {
+		return false, err
+	}
+
+	return false, syncProductQuantities(tx, []int{productID})
+}
+
+// findImportedVariant returns the product and variant an import row updates,
+// or zeros when it describes a new product.
+func findImportedVariant(tx *sql.Tx, product types.CreateProductPayload) (int, int, error) {
+	var productID, variantID int
+	var archived bool
+
+	if product.SKU != "" {
+		err := tx.QueryRow(`
+			SELECT v.productId, v.id, p.deletedAt IS NOT NULL
+			FROM product_variants v
+			JOIN products p ON p.id = v.productId
+			WHERE v.sku = $1
+			FOR UPDATE`, product.SKU).Scan(&productID, &variantID, &archived)
+		if err == sql.ErrNoRows {
+			return 0, 0, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		if archived {
+			return 0, 0, fmt.Errorf("product %d with sku %s is archived, restore it first", productID, product.SKU)
+		}
+
+		return productID, variantID, nil
+	}
+
+	rows, err := tx.Query("SELECT id FROM products WHERE name = $1 AND deletedAt IS NULL FOR UPDATE", product.Name)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	matches := 0
+	for rows.Next() {
+		if err := rows.Scan(&productID); err != nil {
+			return 0, 0, err
+		}
+		matches++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	rows.Close()
+
+	switch {
+	case matches == 0:
+		return 0, 0, nil
+	case matches > 1:
+		return 0, 0, fmt.Errorf("several products are named %q, give the sku to pick one", product.Name)
+	}
+
+	variantIDs, err := lockProductVariantIDs(tx, productID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(variantIDs) != 1 {
+		return 0, 0, fmt.Errorf("product %q has several variants, give the sku to pick one", product.Name)
+	}
+
+	return productID, variantIDs[0], nil
+}
+
+// ExportProducts calls fn with every variant of the catalog, as a row in the
+// import format, stopping at the first error. Archived products are left out.
+func (s *Store) ExportProducts(fn func(types.CreateProductPayload) error) error {
+	rows, err := s.db.Query(`
+		SELECT v.sku, p.name, p.description, p.image, p.price, v.quantity
+		FROM product_variants v
+		JOIN products p ON p.id = v.productId
+		WHERE p.deletedAt IS NULL
+		ORDER BY p.id, v.id`)
+	if err != nil {
+		return fmt.Errorf("failed to export products: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p types.CreateProductPayload
+		if err := rows.Scan(&p.SKU, &p.Name, &p.Description, &p.Image, &p.Price, &p.Quantity); err != nil {
+			return err
+		}
+
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,34 @@
+package product
+
+import (
+	"net/http"
+
+	"github.com/duziem/ecommerce_proj/utils"
+)
+
+func (h *Handler) handleGetInventory(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	limit, offset, err := parsePage(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := h.store.GetProductByID(productID); err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	page, err := h.store.GetInventoryMovements(productID, limit, offset)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, page)
+}
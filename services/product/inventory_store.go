@@ -0,0 +1,136 @@
+package product
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+// RecordInventoryMovements logs stock changes, in the transaction that made them.
+func (s *Store) RecordInventoryMovements(tx *sql.Tx, movements []types.InventoryMovement) error {
+	return recordInventoryMovements(tx, movements)
+}
+
+func recordInventoryMovements(tx *sql.Tx, movements []types.InventoryMovement) error {
+	if len(movements) == 0 {
+		return nil
+	}
+
+	query := `
+			INSERT INTO inventory_movements (productId, variantId, orderId, quantity, reason)
+			VALUES %s;
+	`
+
+	var args []interface{}
+	var placeholders []string
+	for i, m := range movements {
+		placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", i*5+1, i*5+2, i*5+3, i*5+4, i*5+5))
+		args = append(args, m.ProductID, m.VariantID, m.OrderID, m.Quantity, m.Reason)
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(query, strings.Join(placeholders, ", ")), args...); err != nil {
+		return fmt.Errorf("failed to record inventory movements: %w", err)
+	}
+
+	return nil
+}
+
+// recordStockChange logs a change of delta to the variant's stock, if any.
+func recordStockChange(tx *sql.Tx, productID, variantID, delta int, reason string) error {
+	if delta == 0 {
+		return nil
+	}
+
+	return recordInventoryMovements(tx, []types.InventoryMovement{
+		{ProductID: productID, VariantID: &variantID, Quantity: delta, Reason: reason},
+	})
+}
+
+// lockVariantQuantity returns the variant's stock, locking the variant until
+// the transaction ends so the change made to it can be recorded exactly.
+func lockVariantQuantity(tx *sql.Tx, variantID int) (int, error) {
+	var quantity int
+	err := tx.QueryRow("SELECT quantity FROM product_variants WHERE id = $1 FOR UPDATE", variantID).Scan(&quantity)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock variant %d: %w", variantID, err)
+	}
+
+	return quantity, nil
+}
+
+// GetInventoryMovements returns a page of the product's stock history, most
+// recent first, along with its current stock.
+func (s *Store) GetInventoryMovements(productID, limit, offset int) (*types.InventoryMovementPage, error) {
+	page := &types.InventoryMovementPage{
+		ProductID: productID,
+		Data:      []*types.InventoryMovement{},
+		Metadata:  types.PaginationMetadata{Limit: limit, Offset: offset},
+	}
+
+	err := s.db.QueryRow("SELECT quantity FROM products WHERE id = $1", productID).Scan(&page.Quantity)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("product not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.QueryRow("SELECT COUNT(*) FROM inventory_movements WHERE productId = $1", productID).Scan(&page.Metadata.TotalCount)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+			SELECT id, productId, variantId, orderId, quantity, reason, createdAt
+			FROM inventory_movements
+			WHERE productId = $1
+			ORDER BY createdAt DESC, id DESC
+			LIMIT $2 OFFSET $3;
+	`, productID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m := new(types.InventoryMovement)
+		if err := rows.Scan(&m.ID, &m.ProductID, &m.VariantID, &m.OrderID, &m.Quantity, &m.Reason, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		page.Data = append(page.Data, m)
+	}
+
+	return page, rows.Err()
+}
+
+// GetInventoryDiscrepancies returns the variants whose stock differs from
+// the sum of their recorded movements.
+func (s *Store) GetInventoryDiscrepancies() ([]types.InventoryDiscrepancy, error) {
+	rows, err := s.db.Query(`
+			SELECT v.productId, v.id, v.sku, v.quantity, COALESCE(SUM(m.quantity), 0)
+			FROM product_variants v
+			LEFT JOIN inventory_movements m ON m.variantId = v.id
+			GROUP BY v.id
+			HAVING v.quantity <> COALESCE(SUM(m.quantity), 0)
+			ORDER BY v.productId, v.id;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var discrepancies []types.InventoryDiscrepancy
+	for rows.Next() {
+		var d types.InventoryDiscrepancy
+		if err := rows.Scan(&d.ProductID, &d.VariantID, &d.SKU, &d.Quantity, &d.LedgerQuantity); err != nil {
+			return nil, err
+		}
+
+		discrepancies = append(discrepancies, d)
+	}
+
+	return discrepancies, rows.Err()
+}
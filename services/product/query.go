@@ -0,0 +1,276 @@
+package product
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+
+	maxSearchQueryLength = 200
+)
+
+// sortable columns and the type their cursor value is cast to
+var sortColumns = map[string]struct {
+	column string
+	cast   string
+}{
+	"price":     {column: "price", cast: "numeric"},
+	"name":      {column: "name", cast: "text"},
+	"createdAt": {column: "createdAt", cast: "timestamp"},
+}
+
+type productCursor struct {
+	SortBy    string `json:"sortBy"`
+	SortOrder string `json:"sortOrder"`
+	Value     string `json:"value"`
+	ID        int    `json:"id"`
+}
+
+func parseProductQueryOptions(query url.Values) (types.ProductQueryOptions, error) {
+	opts := types.ProductQueryOptions{
+		Limit:     defaultPageLimit,
+		SortBy:    "createdAt",
+		SortOrder: "desc",
+	}
+
+	if str := query.Get("limit"); str != "" {
+		limit, err := strconv.Atoi(str)
+		if err != nil || limit <= 0 {
+			return opts, fmt.Errorf("invalid limit")
+		}
+		opts.Limit = min(limit, maxPageLimit)
+	}
+
+	if str := query.Get("offset"); str != "" {
+		offset, err := strconv.Atoi(str)
+		if err != nil || offset < 0 {
+			return opts, fmt.Errorf("invalid offset")
+		}
+		opts.Offset = offset
+	}
+
+	if str := query.Get("minPrice"); str != "" {
+		price, err := types.ParseMoney(str, "")
+		if err != nil || price.Amount < 0 {
+			return opts, fmt.Errorf("invalid minPrice")
+		}
+		opts.MinPrice = &price
+	}
+
+	if str := query.Get("maxPrice"); str != "" {
+		price, err := types.ParseMoney(str, "")
+		if err != nil || price.Amount < 0 {
+			return opts, fmt.Errorf("invalid maxPrice")
+		}
+		opts.MaxPrice = &price
+	}
+
+	if opts.MinPrice != nil && opts.MaxPrice != nil && opts.MinPrice.Amount > opts.MaxPrice.Amount {
+		return opts, fmt.Errorf("minPrice cannot be greater than maxPrice")
+	}
+
+	if str := query.Get("inStock"); str != "" {
+		inStock, err := strconv.ParseBool(str)
+		if err != nil {
+			return opts, fmt.Errorf("invalid inStock")
+		}
+		opts.InStock = inStock
+	}
+
+	if str := query.Get("createdAfter"); str != "" {
+		createdAfter, err := parseTime(str)
+		if err != nil {
+			return opts, fmt.Errorf("invalid createdAfter, expected RFC3339 or YYYY-MM-DD")
+		}
+		opts.CreatedAfter = &createdAfter
+	}
+
+	if str := query.Get("sortBy"); str != "" {
+		if _, ok := sortColumns[str]; !ok {
+			return opts, fmt.Errorf("invalid sortBy, expected one of price, name, createdAt")
+		}
+		opts.SortBy = str
+	}
+
+	if str := query.Get("sortOrder"); str != "" {
+		str = strings.ToLower(str)
+		if str != "asc" && str != "desc" {
+			return opts, fmt.Errorf("invalid sortOrder, expected asc or desc")
+		}
+		opts.SortOrder = str
+	}
+
+	if str := query.Get("cursor"); str != "" {
+		if opts.Offset > 0 {
+			return opts, fmt.Errorf("cursor and offset cannot be combined")
+		}
+
+		cursor, err := decodeProductCursor(str)
+		if err != nil {
+			return opts, err
+		}
+		if cursor.SortBy != opts.SortBy || cursor.SortOrder != opts.SortOrder {
+			return opts, fmt.Errorf("cursor does not match the requested sort")
+		}
+		opts.Cursor = str
+	}
+
+	return opts, nil
+}
+
+func parseSearchQuery(query url.Values) (string, int, int, error) {
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		return "", 0, 0, fmt.Errorf("missing search query")
+	}
+	if len(q) > maxSearchQueryLength {
+		return "", 0, 0, fmt.Errorf("search query is too long")
+	}
+	if buildPrefixTSQuery(q) == "" {
+		return "", 0, 0, fmt.Errorf("search query has no searchable terms")
+	}
+
+	limit, offset, err := parsePage(query)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	return q, limit, offset, nil
+}
+
+// parsePage reads the ?limit=&offset= of an offset paginated list.
+func parsePage(query url.Values) (int, int, error) {
+	limit := defaultPageLimit
+	if str := query.Get("limit"); str != "" {
+		l, err := strconv.Atoi(str)
+		if err != nil || l <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit")
+		}
+		limit = min(l, maxPageLimit)
+	}
+
+	offset := 0
+	if str := query.Get("offset"); str != "" {
+		o, err := strconv.Atoi(str)
+		if err != nil || o < 0 {
+			return 0, 0, fmt.Errorf("invalid offset")
+		}
+		offset = o
+	}
+
+	return limit, offset, nil
+}
+
+// buildPrefixTSQuery turns free text into a to_tsquery expression where every
+// word must match as a prefix, e.g. "red sho" becomes "red:* & sho:*".
+// Anything that isn't a letter or digit is dropped so user input can never
+// inject tsquery operators.
+func buildPrefixTSQuery(q string) string {
+	words := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, word+":*")
+	}
+
+	return strings.Join(terms, " & ")
+}
+
+func parseTime(str string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t, nil
+	}
+
+	return time.Parse("2006-01-02", str)
+}
+
+func encodeProductCursor(opts types.ProductQueryOptions, p *types.Product) string {
+	cursor := productCursor{
+		SortBy:    opts.SortBy,
+		SortOrder: opts.SortOrder,
+		ID:        p.ID,
+	}
+
+	switch opts.SortBy {
+	case "price":
+		cursor.Value = p.Price.String()
+	case "name":
+		cursor.Value = p.Name
+	default:
+		cursor.Value = p.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeProductCursor(str string) (*productCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	cursor := new(productCursor)
+	if err := json.Unmarshal(b, cursor); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	if _, ok := sortColumns[cursor.SortBy]; !ok || cursor.ID <= 0 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return cursor, nil
+}
+
+// buildProductFilters returns the WHERE conditions shared by the page query
+// and its count, along with their positional arguments.
+func buildProductFilters(opts types.ProductQueryOptions) ([]string, []interface{}) {
+	conditions := []string{"deletedAt IS NULL"}
+	var args []interface{}
+
+	if opts.MinPrice != nil {
+		args = append(args, *opts.MinPrice)
+		conditions = append(conditions, fmt.Sprintf("price >= $%d", len(args)))
+	}
+	if opts.MaxPrice != nil {
+		args = append(args, *opts.MaxPrice)
+		conditions = append(conditions, fmt.Sprintf("price <= $%d", len(args)))
+	}
+	// stock held for unpaid orders can't be sold
+	if opts.InStock {
+		conditions = append(conditions, "quantity > (SELECT COALESCE(SUM(r.quantity), 0) FROM inventory_reservations r WHERE r.productId = products.id AND r.status = 'active')")
+	}
+	if opts.CreatedAfter != nil {
+		args = append(args, opts.CreatedAfter.UTC())
+		conditions = append(conditions, fmt.Sprintf("createdAt > $%d", len(args)))
+	}
+	if len(opts.CategoryIDs) > 0 {
+		args = append(args, pq.Array(opts.CategoryIDs))
+		conditions = append(conditions, fmt.Sprintf(
+			"id IN (SELECT productId FROM product_categories WHERE categoryId = ANY($%d))", len(args)))
+	}
+
+	return conditions, args
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
@@ -0,0 +1,149 @@
+package product
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/lib/pq"
+)
+
+// ReserveStock holds the items' quantities for the order until expiresAt.
+// Every item must have its VariantID resolved.
+func (s *Store) ReserveStock(tx *sql.Tx, orderID int, items []types.CartCheckoutItem, expiresAt time.Time) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	query := `
+			INSERT INTO inventory_reservations (orderId, productId, variantId, quantity, expiresAt)
+			VALUES %s;
+	`
+
+	args := []interface{}{orderID, expiresAt.UTC()}
+	var placeholders []string
+	for i, item := range items {
+		placeholders = append(placeholders, fmt.Sprintf("($1, $%d, $%d, $%d, $2)", i*3+3, i*3+4, i*3+5))
+		args = append(args, item.ProductID, item.VariantID, item.Quantity)
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(query, strings.Join(placeholders, ", ")), args...); err != nil {
+		return fmt.Errorf("failed to reserve stock: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) GetOrderReservationsWithLock(tx *sql.Tx, orderID int) ([]types.InventoryReservation, error) {
+	rows, err := tx.Query(`
+			SELECT id, orderId, productId, variantId, quantity, status, expiresAt, createdAt
+			FROM inventory_reservations
+			WHERE orderId = $1
+			ORDER BY id
+			FOR UPDATE;
+	`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch reservations: %w", err)
+	}
+	defer rows.Close()
+
+	var reservations []types.InventoryReservation
+	for rows.Next() {
+		var r types.InventoryReservation
+		err := rows.Scan(&r.ID, &r.OrderID, &r.ProductID, &r.VariantID, &r.Quantity, &r.Status, &r.ExpiresAt, &r.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		reservations = append(reservations, r)
+	}
+
+	return reservations, rows.Err()
+}
+
+// UpdateReservationsStatus commits or releases the order's active reservations.
+func (s *Store) UpdateReservationsStatus(tx *sql.Tx, orderID int, status types.ReservationStatus) error {
+	_, err := tx.Exec("UPDATE inventory_reservations SET status = $1 WHERE orderId = $2 AND status = $3",
+		status, orderID, types.ReservationStatusActive)
+	if err != nil {
+		return fmt.Errorf("failed to update reservations: %w", err)
+	}
+
+	return nil
+}
+
+// GetExpiredReservationOrderIDs returns up to limit orders holding
+// reservations that expired before now.
+func (s *Store) GetExpiredReservationOrderIDs(now time.Time, limit int) ([]int, error) {
+	rows, err := s.db.Query(`
+			SELECT DISTINCT orderId
+			FROM inventory_reservations
+			WHERE status = $1 AND expiresAt < $2
+			ORDER BY orderId
+			LIMIT $3;
+	`, types.ReservationStatusActive, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// attachReservedQuantities fills in the reserved and available stock of the
+// variants. It runs as its own statement so that, after the variants were
+// locked, it sees the reservations committed while waiting for the lock.
+func attachReservedQuantities(q querier, variants []*types.ProductVariant) error {
+	if len(variants) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(variants))
+	for i, v := range variants {
+		ids[i] = v.ID
+	}
+
+	rows, err := q.Query(`
+			SELECT variantId, SUM(quantity)
+			FROM inventory_reservations
+			WHERE variantId = ANY($1) AND status = $2
+			GROUP BY variantId;
+	`, pq.Array(ids), types.ReservationStatusActive)
+	if err != nil {
+		return fmt.Errorf("failed to fetch reserved quantities: %w", err)
+	}
+	defer rows.Close()
+
+	reserved := make(map[int]int)
+	for rows.Next() {
+		var id, quantity int
+		if err := rows.Scan(&id, &quantity); err != nil {
+			return err
+		}
+
+		reserved[id] = quantity
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, v := range variants {
+		v.Reserved = reserved[v.ID]
+		v.Available = max(v.Quantity-v.Reserved, 0)
+	}
+
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/duziem/ecommerce_proj/services/auth"
+	"github.com/duziem/ecommerce_proj/services/media"
 	"github.com/duziem/ecommerce_proj/types"
 	"github.com/duziem/ecommerce_proj/utils"
 	"github.com/go-playground/validator/v10"
@@ -14,31 +15,74 @@ import (
 )
 
 type Handler struct {
-	store     types.ProductStore
-	userStore types.UserStore
+	store         types.ProductStore
+	categoryStore types.CategoryStore
+	userStore     types.UserStore
+	storage       media.Storage
 }
 
-func NewHandler(store types.ProductStore, userStore types.UserStore) *Handler {
-	return &Handler{store: store, userStore: userStore}
+func NewHandler(store types.ProductStore, categoryStore types.CategoryStore, userStore types.UserStore, storage media.Storage) *Handler {
+	return &Handler{store: store, categoryStore: categoryStore, userStore: userStore, storage: storage}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	// get list of products
+	// get list of products, paginated with ?limit=&offset= or ?cursor=
+	// filters: minPrice, maxPrice, inStock, createdAfter; sorting: sortBy, sortOrder
 	router.HandleFunc("/products", auth.WithJWTAuth(h.handleGetProducts, h.userStore)).Methods(http.MethodGet)
 
+	// full-text search over product names and descriptions, ?q=&limit=&offset=
+	// registered before /products/{productID} so "search" isn't taken as an ID
+	router.HandleFunc("/products/search", auth.WithJWTAuth(h.handleSearchProducts, h.userStore)).Methods(http.MethodGet)
+
 	// get a single product
 	router.HandleFunc("/products/{productID}", auth.WithJWTAuth(h.handleGetProduct, h.userStore)).Methods(http.MethodGet)
 
+	// get products in a category and all of its subcategories, same query options as /products
+	router.HandleFunc("/categories/{slug}/products", auth.WithJWTAuth(h.handleGetCategoryProducts, h.userStore)).Methods(http.MethodGet)
+
+	// get the variants of a product
+	router.HandleFunc("/products/{productID}/variants", auth.WithJWTAuth(h.handleGetVariants, h.userStore)).Methods(http.MethodGet)
+
+	// get the images of a product in display order
+	router.HandleFunc("/products/{productID}/images", auth.WithJWTAuth(h.handleGetImages, h.userStore)).Methods(http.MethodGet)
+
 	// admin routes
+	// import products from CSV or NDJSON, matched by sku or name
+	// ?format=csv|ndjson (or the Content-Type), dryRun, mode=atomic|chunked, chunkSize
+	router.HandleFunc("/admin/products/import", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleImportProducts, h.userStore), h.userStore)).Methods(http.MethodPost)
+	// export the catalog in the import format, ?format=csv|ndjson
+	router.HandleFunc("/admin/products/export", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsRead, h.handleExportProducts, h.userStore), h.userStore)).Methods(http.MethodGet)
 	// create a product
-	router.HandleFunc("/admin/products", auth.WithJWTAuth(auth.WithAdminRole(h.handleCreateProduct, h.userStore), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/products", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleCreateProduct, h.userStore), h.userStore)).Methods(http.MethodPost)
 	// update a product
-	router.HandleFunc("/admin/products/{productID}", auth.WithJWTAuth(auth.WithAdminRole(h.handleUpdateProduct, h.userStore), h.userStore)).Methods(http.MethodPatch)
-	// delete a product
-	router.HandleFunc("/admin/products/{productID}", auth.WithJWTAuth(auth.WithAdminRole(h.handleDeleteProduct, h.userStore), h.userStore)).Methods(http.MethodDelete)
-
-	// delete products
-	router.HandleFunc("/admin/products", auth.WithJWTAuth(auth.WithAdminRole(h.handleDeleteProducts, h.userStore), h.userStore)).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/products/{productID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleUpdateProduct, h.userStore), h.userStore)).Methods(http.MethodPatch)
+	// archive a product, it stays in order history and can be restored
+	router.HandleFunc("/admin/products/{productID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleDeleteProduct, h.userStore), h.userStore)).Methods(http.MethodDelete)
+
+	// archive products
+	router.HandleFunc("/admin/products", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleDeleteProducts, h.userStore), h.userStore)).Methods(http.MethodDelete)
+
+	// replace the options (e.g. size, color) a product's variants choose from
+	router.HandleFunc("/admin/products/{productID}/options", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleSetProductOptions, h.userStore), h.userStore)).Methods(http.MethodPut)
+	// create a variant
+	router.HandleFunc("/admin/products/{productID}/variants", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleCreateVariant, h.userStore), h.userStore)).Methods(http.MethodPost)
+	// update a variant
+	router.HandleFunc("/admin/products/{productID}/variants/{variantID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleUpdateVariant, h.userStore), h.userStore)).Methods(http.MethodPatch)
+	// delete a variant
+	router.HandleFunc("/admin/products/{productID}/variants/{variantID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleDeleteVariant, h.userStore), h.userStore)).Methods(http.MethodDelete)
+
+	// upload an image as multipart/form-data in the "image" field, it is added after the others
+	router.HandleFunc("/admin/products/{productID}/images", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleUploadImage, h.userStore), h.userStore)).Methods(http.MethodPost)
+	// reorder the images of a product, the first one becomes its main image
+	router.HandleFunc("/admin/products/{productID}/images/order", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleReorderImages, h.userStore), h.userStore)).Methods(http.MethodPut)
+	// delete an image and its thumbnails
+	router.HandleFunc("/admin/products/{productID}/images/{imageID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleDeleteImage, h.userStore), h.userStore)).Methods(http.MethodDelete)
+
+	// restore an archived product
+	router.HandleFunc("/admin/products/{productID}/restore", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsWrite, h.handleRestoreProduct, h.userStore), h.userStore)).Methods(http.MethodPost)
+
+	// get the stock movements of a product, most recent first, paginated with ?limit=&offset=
+	router.HandleFunc("/admin/products/{productID}/inventory", auth.WithJWTAuth(auth.RequirePermission(types.PermissionProductsRead, h.handleGetInventory, h.userStore), h.userStore)).Methods(http.MethodGet)
 }
 
 func (h *Handler) handleDeleteProducts(w http.ResponseWriter, r *http.Request) {
@@ -75,13 +119,63 @@ func (h *Handler) handleDeleteProducts(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) handleGetProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := h.store.GetProducts()
+	opts, err := parseProductQueryOptions(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := h.store.GetProducts(opts)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, page)
+}
+
+func (h *Handler) handleGetCategoryProducts(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseProductQueryOptions(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	category, err := h.categoryStore.GetCategoryBySlug(mux.Vars(r)["slug"])
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	opts.CategoryIDs, err = h.categoryStore.GetDescendantIDs(category.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	page, err := h.store.GetProducts(opts)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, products)
+	utils.WriteJSON(w, http.StatusOK, page)
+}
+
+func (h *Handler) handleSearchProducts(w http.ResponseWriter, r *http.Request) {
+	q, limit, offset, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	results, err := h.store.SearchProducts(q, limit, offset)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, results)
 }
 
 func (h *Handler) handleGetProduct(w http.ResponseWriter, r *http.Request) {
@@ -103,6 +197,16 @@ func (h *Handler) handleGetProduct(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
+	if product.DeletedAt != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	product.Images, err = h.getImages(product.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
 
 	utils.WriteJSON(w, http.StatusOK, product)
 }
@@ -123,6 +227,11 @@ func (h *Handler) handleUpdateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := validatePriceCurrency(productPayload.Price); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
 	vars := mux.Vars(r)
 	str, ok := vars["productID"]
 	if !ok {
@@ -155,11 +264,19 @@ func (h *Handler) handleUpdateProduct(w http.ResponseWriter, r *http.Request) {
 	if productPayload.Price != nil {
 		product.Price = *productPayload.Price
 	}
-	if productPayload.Quantity != nil {
-		product.Quantity = *productPayload.Quantity
+	if productPayload.Quantity != nil && len(product.Variants) > 1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("product has several variants, update the stock of each variant instead"))
+		return
 	}
 
-	err = h.store.UpdateProduct(*product) // Pass the updated Product object (dereferencing the pointer)
+	// the stock is only touched when a quantity is given
+	err = h.store.UpdateProduct(*product, productPayload.Quantity) // Pass the updated Product object (dereferencing the pointer)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	product, err = h.store.GetProductByID(productID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -187,7 +304,7 @@ func (h *Handler) handleDeleteProduct(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
-	if product == nil { // Check if the product was found
+	if product == nil || product.DeletedAt != nil { // Check if the product was found
 		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product not found"))
 		return
 	}
@@ -205,6 +322,32 @@ func (h *Handler) handleDeleteProduct(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, http.StatusOK, response)
 }
 
+func (h *Handler) handleRestoreProduct(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	product, err := h.store.GetProductByID(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if product.DeletedAt == nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("product is not archived"))
+		return
+	}
+
+	if err := h.store.RestoreProduct(productID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	product.DeletedAt = nil
+	utils.WriteJSON(w, http.StatusOK, product)
+}
+
 func (h *Handler) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
 	var product types.CreateProductPayload
 	if err := utils.ParseJSON(r, &product); err != nil {
@@ -218,6 +361,11 @@ func (h *Handler) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := validatePriceCurrency(&product.Price); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
 	err := h.store.CreateProduct(product)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
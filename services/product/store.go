@@ -4,11 +4,15 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/duziem/ecommerce_proj/types"
 	"github.com/lib/pq"
 )
 
+// productColumns lists the products columns read by scanRowsIntoProduct, in scan order.
+const productColumns = "id, name, description, image, price, quantity, createdAt, deletedAt"
+
 type Store struct {
 	db *sql.DB
 }
@@ -17,8 +21,10 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
+// GetProductByID also returns archived products, callers serving the
+// storefront must check DeletedAt.
 func (s *Store) GetProductByID(productID int) (*types.Product, error) {
-	rows, err := s.db.Query("SELECT * FROM products WHERE id = $1", productID)
+	rows, err := s.db.Query("SELECT "+productColumns+" FROM products WHERE id = $1", productID)
 	if err != nil {
 		return nil, err
 	}
@@ -35,6 +41,17 @@ func (s *Store) GetProductByID(productID int) (*types.Product, error) {
 		return nil, fmt.Errorf("product not found")
 	}
 
+	variants, err := getVariantsByProductIDs(s.db, []int{p.ID}, false)
+	if err != nil {
+		return nil, err
+	}
+	p.Variants = variants[p.ID]
+
+	p.Options, err = s.GetProductOptions(p.ID)
+	if err != nil {
+		return nil, err
+	}
+
 	return p, nil
 }
 
@@ -45,7 +62,7 @@ func (s *Store) GetProductsByID(productIDs []int) ([]types.Product, error) {
 		placeholders[i] = fmt.Sprintf("$%d", i+1) // Create placeholders $1, $2, ...
 	}
 
-	query := fmt.Sprintf("SELECT * FROM products WHERE id IN (%s)", strings.Join(placeholders, ","))
+	query := fmt.Sprintf("SELECT %s FROM products WHERE id IN (%s) AND deletedAt IS NULL", productColumns, strings.Join(placeholders, ","))
 	// Convert productIDs to []interface{}
 	args := make([]interface{}, len(productIDs))
 	for i, v := range productIDs {
@@ -67,8 +84,11 @@ func (s *Store) GetProductsByID(productIDs []int) ([]types.Product, error) {
 		products = append(products, *p)
 	}
 
-	return products, nil
+	if err := attachVariants(s.db, products, false); err != nil {
+		return nil, err
+	}
 
+	return products, nil
 }
 
 func (s *Store) DeleteProducts(productIDs []int) error {
@@ -78,7 +98,8 @@ func (s *Store) DeleteProducts(productIDs []int) error {
 		placeholders[i] = fmt.Sprintf("$%d", i+1) // Create placeholders $1, $2, ...
 	}
 
-	query := fmt.Sprintf("DELETE FROM products WHERE id IN (%s)", strings.Join(placeholders, ","))
+	// products are archived rather than deleted so order history keeps them
+	query := fmt.Sprintf("UPDATE products SET deletedAt = NOW() WHERE id IN (%s) AND deletedAt IS NULL", strings.Join(placeholders, ","))
 	// Convert productIDs to []interface{}
 	args := make([]interface{}, len(productIDs))
 	for i, v := range productIDs {
@@ -93,13 +114,58 @@ func (s *Store) DeleteProducts(productIDs []int) error {
 	return nil
 }
 
-func (s *Store) GetProducts() ([]*types.Product, error) {
-	rows, err := s.db.Query("SELECT * FROM products")
+func (s *Store) GetProducts(opts types.ProductQueryOptions) (*types.ProductPage, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = defaultPageLimit
+	}
+
+	conditions, args := buildProductFilters(opts)
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM products"+whereClause(conditions), args...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count products: %w", err)
+	}
+
+	sort, ok := sortColumns[opts.SortBy]
+	if !ok {
+		sort = sortColumns["createdAt"]
+	}
+	order := "ASC"
+	comparison := ">"
+	if opts.SortOrder == "desc" {
+		order = "DESC"
+		comparison = "<"
+	}
+
+	// keyset pagination: continue strictly after the (sort value, id) of the cursor row
+	if opts.Cursor != "" {
+		cursor, err := decodeProductCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, cursor.Value, cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d::%s, $%d)",
+			sort.column, comparison, len(args)-1, sort.cast, len(args)))
+	}
+
+	// fetch one extra row to know whether another page exists
+	args = append(args, opts.Limit+1)
+	query := fmt.Sprintf("SELECT %s FROM products%s ORDER BY %s %s, id %s LIMIT $%d",
+		productColumns, whereClause(conditions), sort.column, order, order, len(args))
+
+	if opts.Cursor == "" && opts.Offset > 0 {
+		args = append(args, opts.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to fetch products: %w", err)
 	}
+	defer rows.Close()
 
-	products := make([]*types.Product, 0)
+	products := make([]*types.Product, 0, opts.Limit)
 	for rows.Next() {
 		p, err := scanRowsIntoProduct(rows)
 		if err != nil {
@@ -109,48 +175,206 @@ func (s *Store) GetProducts() ([]*types.Product, error) {
 		products = append(products, p)
 	}
 
-	return products, nil
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	page := &types.ProductPage{
+		Data: products,
+		Metadata: types.PaginationMetadata{
+			TotalCount: total,
+			Limit:      opts.Limit,
+			Offset:     opts.Offset,
+		},
+	}
+
+	if len(products) > opts.Limit {
+		page.Data = products[:opts.Limit]
+		page.Metadata.NextCursor = encodeProductCursor(opts, page.Data[opts.Limit-1])
+	}
+
+	return page, nil
+}
+
+// SearchProducts ranks products against a full-text query over name and
+// description. Every term is matched as a prefix, and names that are merely
+// similar to the query (typos) are included with a lower rank.
+func (s *Store) SearchProducts(query string, limit, offset int) (*types.ProductSearchPage, error) {
+	tsQuery := buildPrefixTSQuery(query)
+	if tsQuery == "" {
+		return nil, fmt.Errorf("search query has no searchable terms")
+	}
+
+	const matches = `
+		FROM products, to_tsquery('english', $1) AS query
+		WHERE deletedAt IS NULL AND (searchVector @@ query OR $2 <% name)`
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) "+matches, tsQuery, query).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count search results: %w", err)
+	}
+
+	searchQuery := `
+		SELECT ` + productColumns + `,
+			ts_rank_cd(searchVector, query) + word_similarity($2, name) AS rank,
+			ts_headline('english', name, query, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'),
+			ts_headline('english', description, query, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=25, MinWords=10')
+		` + matches + `
+		ORDER BY rank DESC, id
+		LIMIT $3 OFFSET $4`
+
+	rows, err := s.db.Query(searchQuery, tsQuery, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search products: %w", err)
+	}
+	defer rows.Close()
+
+	results := make([]*types.ProductSearchResult, 0, limit)
+	for rows.Next() {
+		r := new(types.ProductSearchResult)
+		err := rows.Scan(
+			&r.ID,
+			&r.Name,
+			&r.Description,
+			&r.Image,
+			&r.Price,
+			&r.Quantity,
+			&r.CreatedAt,
+			&r.DeletedAt,
+			&r.Rank,
+			&r.NameHighlight,
+			&r.DescriptionHighlight,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return &types.ProductSearchPage{
+		Data: results,
+		Metadata: types.PaginationMetadata{
+			TotalCount: total,
+			Limit:      limit,
+			Offset:     offset,
+		},
+	}, nil
 }
 
+// CreateProduct inserts the product together with its initial variant, which
+// holds all of the product's stock.
 func (s *Store) CreateProduct(product types.CreateProductPayload) error {
-	_, err := s.db.Exec("INSERT INTO products (name, price, image, description, quantity) VALUES ($1, $2, $3, $4, $5)",
-		product.Name, product.Price, product.Image, product.Description, product.Quantity)
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	if _, err := insertProduct(tx, product, types.InventoryReasonInitial); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// Store method to update a product in the database
-func (s *Store) UpdateProduct(product types.Product) error {
+// insertProduct inserts the product and its initial variant, recording the
+// initial stock with the given reason.
+func insertProduct(tx *sql.Tx, product types.CreateProductPayload, reason string) (int, error) {
+	var productID int
+	err := tx.QueryRow("INSERT INTO products (name, price, image, description, quantity) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		product.Name, product.Price, product.Image, product.Description, product.Quantity).Scan(&productID)
+	if err != nil {
+		return 0, err
+	}
+
+	sku := product.SKU
+	if sku == "" {
+		sku = fmt.Sprintf("SKU-%d", productID)
+	}
+
+	var variantID int
+	err = tx.QueryRow("INSERT INTO product_variants (productId, sku, quantity) VALUES ($1, $2, $3) RETURNING id",
+		productID, sku, product.Quantity).Scan(&variantID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create variant: %w", err)
+	}
+
+	if err := recordStockChange(tx, productID, variantID, product.Quantity, reason); err != nil {
+		return 0, err
+	}
+
+	return productID, nil
+}
+
+// Store method to update a product in the database.
+// A non-nil quantity is only applied to products with a single variant, the
+// stock of other products is managed per variant.
+func (s *Store) UpdateProduct(product types.Product, quantity *int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 	      UPDATE products
 	      SET name = COALESCE(NULLIF($1, ''), name),
 	          price = COALESCE($2, price),
 	          image = COALESCE(NULLIF($3, ''), image),
-	          description = COALESCE(NULLIF($4, ''), description),
-	          quantity = COALESCE($5, quantity)
-	      WHERE id = $6`
+	          description = COALESCE(NULLIF($4, ''), description)
+	      WHERE id = $5`
 
-	_, err := s.db.Exec(query,
+	_, err = tx.Exec(query,
 		product.Name,
 		product.Price,
 		product.Image,
 		product.Description,
-		product.Quantity,
 		product.ID,
 	)
+	if err != nil {
+		return err
+	}
+
+	if quantity == nil {
+		return tx.Commit()
+	}
 
+	variantIDs, err := lockProductVariantIDs(tx, product.ID)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if len(variantIDs) == 1 {
+		// the adjustment is worked out from the locked stock, sales made
+		// since the product was read are kept in the ledger
+		current, err := lockVariantQuantity(tx, variantIDs[0])
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("UPDATE product_variants SET quantity = $1 WHERE id = $2", *quantity, variantIDs[0]); err != nil {
+			return err
+		}
+
+		if err := recordStockChange(tx, product.ID, variantIDs[0], *quantity-current, types.InventoryReasonManualAdjust); err != nil {
+			return err
+		}
+	}
+
+	if err := syncProductQuantities(tx, []int{product.ID}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (s *Store) DeleteProduct(productID int) error {
-	_, err := s.db.Exec("DELETE FROM products WHERE id = $1", productID)
+	_, err := s.db.Exec("UPDATE products SET deletedAt = NOW() WHERE id = $1 AND deletedAt IS NULL", productID)
 	if err != nil {
 		return err
 	}
@@ -158,6 +382,27 @@ func (s *Store) DeleteProduct(productID int) error {
 	return nil
 }
 
+func (s *Store) RestoreProduct(productID int) error {
+	_, err := s.db.Exec("UPDATE products SET deletedAt = NULL WHERE id = $1", productID)
+	return err
+}
+
+// PurgeProducts deletes the products archived before deletedBefore that
+// were never ordered, and returns how many it deleted. Products that were
+// ordered stay archived for the order history.
+func (s *Store) PurgeProducts(deletedBefore time.Time) (int64, error) {
+	result, err := s.db.Exec(`
+		DELETE FROM products
+		WHERE deletedAt < $1
+		  AND NOT EXISTS (SELECT 1 FROM order_items WHERE order_items.productId = products.id)`,
+		deletedBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge products: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
 func (s *Store) BeginTransaction() (*sql.Tx, error) {
 	tx, err := s.db.Begin()
 	if err != nil {
@@ -168,9 +413,9 @@ func (s *Store) BeginTransaction() (*sql.Tx, error) {
 
 func (s *Store) GetProductsByIDWithLock(tx *sql.Tx, ids []int) ([]types.Product, error) {
 	query := `
-			SELECT * 
-			FROM products 
-			WHERE id = ANY($1) 
+			SELECT ` + productColumns + `
+			FROM products
+			WHERE id = ANY($1)
 			FOR UPDATE;
 	`
 
@@ -193,23 +438,74 @@ func (s *Store) GetProductsByIDWithLock(tx *sql.Tx, ids []int) ([]types.Product,
 		return nil, fmt.Errorf("row iteration error: %w", err)
 	}
 
+	if err := attachVariants(tx, products, true); err != nil {
+		return nil, err
+	}
+
 	return products, nil
 }
 
+// UpdateProductQuantities takes the checked out quantities off each variant's
+// stock. Every item must have its VariantID resolved. Callers record the
+// movements, which know the order the stock went to.
 func (s *Store) UpdateProductQuantities(tx *sql.Tx, cartItems []types.CartCheckoutItem) error {
+	return adjustVariantQuantities(tx, cartItems, "-")
+}
+
+// RestockProducts puts the quantities back on each variant's stock.
+func (s *Store) RestockProducts(tx *sql.Tx, items []types.CartCheckoutItem) error {
+	return adjustVariantQuantities(tx, items, "+")
+}
+
+func lockProductVariantIDs(tx *sql.Tx, productID int) ([]int, error) {
+	rows, err := tx.Query("SELECT id FROM product_variants WHERE productId = $1 ORDER BY id FOR UPDATE", productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// adjustVariantQuantities adds or subtracts, depending on op, each item's
+// quantity to its variant's stock and syncs the product totals.
+func adjustVariantQuantities(tx *sql.Tx, items []types.CartCheckoutItem, op string) error {
 	query := `
-		UPDATE products
-		SET quantity = quantity - excluded.product_quantity::integer
-		FROM (VALUES %s) AS excluded(product_id, product_quantity)
-		WHERE products.id = excluded.product_id::integer;
+		UPDATE product_variants
+		SET quantity = quantity ` + op + ` excluded.variant_quantity::integer
+		FROM (VALUES %s) AS excluded(variant_id, variant_quantity)
+		WHERE product_variants.id = excluded.variant_id::integer;
 	`
 
+	// UPDATE ... FROM changes each row only once, so a variant that is on
+	// several lines gets the sum of their quantities
+	quantities := make(map[int]int)
+	var variantIDs []int
+	productIDs := make([]int, 0, len(items))
+	for _, item := range items {
+		if _, ok := quantities[item.VariantID]; !ok {
+			variantIDs = append(variantIDs, item.VariantID)
+		}
+		quantities[item.VariantID] += item.Quantity
+		productIDs = append(productIDs, item.ProductID)
+	}
+
 	// Build dynamic VALUES clause
 	var args []interface{}
 	var placeholders []string
-	for i, item := range cartItems {
+	for i, id := range variantIDs {
 		placeholders = append(placeholders, fmt.Sprintf("($%d, $%d)", i*2+1, i*2+2))
-		args = append(args, item.ProductID, item.Quantity)
+		args = append(args, id, quantities[id])
 	}
 
 	finalQuery := fmt.Sprintf(query, strings.Join(placeholders, ", "))
@@ -218,7 +514,7 @@ func (s *Store) UpdateProductQuantities(tx *sql.Tx, cartItems []types.CartChecko
 		return fmt.Errorf("failed to update product quantities: %w", err)
 	}
 
-	return nil
+	return syncProductQuantities(tx, productIDs)
 }
 
 func scanRowsIntoProduct(rows *sql.Rows) (*types.Product, error) {
@@ -232,6 +528,7 @@ func scanRowsIntoProduct(rows *sql.Rows) (*types.Product, error) {
 		&product.Price,
 		&product.Quantity,
 		&product.CreatedAt,
+		&product.DeletedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,328 @@
+package product
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/duziem/ecommerce_proj/configs"
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/duziem/ecommerce_proj/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+func (h *Handler) handleGetVariants(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	product, err := h.store.GetProductByID(productID)
+	if err != nil || product.DeletedAt != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	variants, err := h.store.GetVariants(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, variants)
+}
+
+func (h *Handler) handleSetProductOptions(w http.ResponseWriter, r *http.Request) {
+	var payload types.SetProductOptionsPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	productID, err := getProductID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	product, err := h.store.GetProductByID(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	options := make([]types.ProductOption, 0, len(payload.Options))
+	for _, o := range payload.Options {
+		if slices.ContainsFunc(options, func(existing types.ProductOption) bool { return existing.Name == o.Name }) {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("duplicate option %s", o.Name))
+			return
+		}
+
+		options = append(options, types.ProductOption{ProductID: productID, Name: o.Name, Values: o.Values})
+	}
+
+	// existing variants must still be described by the new options
+	for _, v := range product.Variants {
+		if err := validateVariantOptions(options, v.Options); err != nil {
+			utils.WriteError(w, http.StatusConflict, fmt.Errorf("variant %s: %v", v.SKU, err))
+			return
+		}
+	}
+
+	if err := h.store.SetProductOptions(productID, options); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	options, err = h.store.GetProductOptions(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, options)
+}
+
+func (h *Handler) handleCreateVariant(w http.ResponseWriter, r *http.Request) {
+	var payload types.CreateVariantPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	if err := validatePriceCurrency(payload.Price); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	productID, err := getProductID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	product, err := h.store.GetProductByID(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	variant := types.ProductVariant{
+		ProductID:     productID,
+		SKU:           payload.SKU,
+		PriceOverride: payload.Price,
+		Quantity:      payload.Quantity,
+		Options:       payload.Options,
+	}
+
+	if err := validateVariant(product, variant); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	id, err := h.store.CreateVariant(variant)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	created, err := h.store.GetVariantByID(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handler) handleUpdateVariant(w http.ResponseWriter, r *http.Request) {
+	var payload types.UpdateVariantPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	if err := validatePriceCurrency(payload.Price); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	product, variant, ok := h.getProductVariant(w, r)
+	if !ok {
+		return
+	}
+
+	// Update only provided fields
+	if payload.SKU != nil {
+		variant.SKU = *payload.SKU
+	}
+	if payload.Price != nil {
+		variant.PriceOverride = payload.Price
+		if payload.Price.IsZero() {
+			variant.PriceOverride = nil
+		}
+	}
+	if payload.Quantity != nil {
+		variant.Quantity = *payload.Quantity
+	}
+	if payload.Options != nil {
+		variant.Options = payload.Options
+	}
+
+	if err := validateVariant(product, variant); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.UpdateVariant(variant); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	updated, err := h.store.GetVariantByID(variant.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, updated)
+}
+
+func (h *Handler) handleDeleteVariant(w http.ResponseWriter, r *http.Request) {
+	product, variant, ok := h.getProductVariant(w, r)
+	if !ok {
+		return
+	}
+
+	if len(product.Variants) == 1 {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("a product must keep at least one variant"))
+		return
+	}
+
+	if err := h.store.DeleteVariant(variant); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "variant deleted successfully"})
+}
+
+// getProductVariant loads the product and variant named in the route, writing
+// an error response and returning false when either is missing.
+func (h *Handler) getProductVariant(w http.ResponseWriter, r *http.Request) (*types.Product, types.ProductVariant, bool) {
+	productID, err := getProductID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return nil, types.ProductVariant{}, false
+	}
+
+	variantID, err := strconv.Atoi(mux.Vars(r)["variantID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid variant ID"))
+		return nil, types.ProductVariant{}, false
+	}
+
+	product, err := h.store.GetProductByID(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return nil, types.ProductVariant{}, false
+	}
+
+	variant, ok := product.FindVariant(variantID)
+	if !ok || variantID == 0 {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("variant not found"))
+		return nil, types.ProductVariant{}, false
+	}
+
+	return product, variant, true
+}
+
+// validateVariant checks a new or updated variant against its product's
+// options and sibling variants.
+func validateVariant(product *types.Product, variant types.ProductVariant) error {
+	if variant.SKU == "" {
+		return fmt.Errorf("sku cannot be empty")
+	}
+
+	if err := validateVariantOptions(product.Options, variant.Options); err != nil {
+		return err
+	}
+
+	for _, sibling := range product.Variants {
+		if sibling.ID == variant.ID {
+			continue
+		}
+		if sibling.SKU == variant.SKU {
+			return fmt.Errorf("sku %s is already used by another variant", variant.SKU)
+		}
+		if maps.Equal(sibling.Options, variant.Options) {
+			return fmt.Errorf("variant %s already has these options", sibling.SKU)
+		}
+	}
+
+	return nil
+}
+
+// validateVariantOptions checks that every option of a variant is defined on
+// the product and uses one of its values.
+func validateVariantOptions(options []types.ProductOption, variantOptions map[string]string) error {
+	for name, value := range variantOptions {
+		i := slices.IndexFunc(options, func(o types.ProductOption) bool { return o.Name == name })
+		if i == -1 {
+			return fmt.Errorf("unknown option %s", name)
+		}
+
+		if !slices.Contains(options[i].Values, value) {
+			return fmt.Errorf("invalid value %s for option %s", value, name)
+		}
+	}
+
+	return nil
+}
+
+// validatePriceCurrency rejects prices that aren't in the store currency, the
+// only currency the catalog is priced in.
+func validatePriceCurrency(price *types.Money) error {
+	if price != nil && price.Currency != configs.Envs.Currency {
+		return fmt.Errorf("price must be in %s", configs.Envs.Currency)
+	}
+
+	return nil
+}
+
+func getProductID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	str, ok := vars["productID"]
+	if !ok {
+		return 0, fmt.Errorf("missing product ID")
+	}
+
+	productID, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid product ID")
+	}
+
+	return productID, nil
+}
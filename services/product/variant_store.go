@@ -0,0 +1,307 @@
+package product
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/lib/pq"
+)
+
+// variantColumns lists the columns read by scanRowsIntoVariant, in scan order.
+// The product is joined in to resolve the effective price.
+const variantColumns = "v.id, v.productId, v.sku, COALESCE(v.price, p.price), v.price, v.quantity, v.options, v.createdAt"
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func (s *Store) GetProductOptions(productID int) ([]types.ProductOption, error) {
+	rows, err := s.db.Query("SELECT id, productId, name, optionValues FROM product_options WHERE productId = $1 ORDER BY position, id", productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	options := []types.ProductOption{}
+	for rows.Next() {
+		var o types.ProductOption
+		if err := rows.Scan(&o.ID, &o.ProductID, &o.Name, pq.Array(&o.Values)); err != nil {
+			return nil, err
+		}
+
+		options = append(options, o)
+	}
+
+	return options, rows.Err()
+}
+
+// SetProductOptions replaces all of the product's options.
+func (s *Store) SetProductOptions(productID int, options []types.ProductOption) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM product_options WHERE productId = $1", productID); err != nil {
+		return err
+	}
+
+	for i, o := range options {
+		_, err := tx.Exec("INSERT INTO product_options (productId, name, optionValues, position) VALUES ($1, $2, $3, $4)",
+			productID, o.Name, pq.Array(o.Values), i)
+		if err != nil {
+			return fmt.Errorf("failed to create option %s: %w", o.Name, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) GetVariants(productID int) ([]types.ProductVariant, error) {
+	variants, err := getVariantsByProductIDs(s.db, []int{productID}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if variants[productID] == nil {
+		return []types.ProductVariant{}, nil
+	}
+
+	return variants[productID], nil
+}
+
+func (s *Store) GetVariantByID(id int) (*types.ProductVariant, error) {
+	rows, err := s.db.Query("SELECT "+variantColumns+" FROM product_variants v JOIN products p ON p.id = v.productId WHERE v.id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	v := new(types.ProductVariant)
+	for rows.Next() {
+		v, err = scanRowsIntoVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v.ID == 0 {
+		return nil, fmt.Errorf("variant not found")
+	}
+
+	if err := attachReservedQuantities(s.db, []*types.ProductVariant{v}); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func (s *Store) CreateVariant(variant types.ProductVariant) (int, error) {
+	if variant.Options == nil {
+		variant.Options = map[string]string{}
+	}
+
+	options, err := json.Marshal(variant.Options)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow("INSERT INTO product_variants (productId, sku, price, quantity, options) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		variant.ProductID, variant.SKU, variant.PriceOverride, variant.Quantity, options).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create variant: %w", err)
+	}
+
+	if err := recordStockChange(tx, variant.ProductID, id, variant.Quantity, types.InventoryReasonInitial); err != nil {
+		return 0, err
+	}
+
+	if err := syncProductQuantities(tx, []int{variant.ProductID}); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func (s *Store) UpdateVariant(variant types.ProductVariant) error {
+	if variant.Options == nil {
+		variant.Options = map[string]string{}
+	}
+
+	options, err := json.Marshal(variant.Options)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	quantity, err := lockVariantQuantity(tx, variant.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE product_variants SET sku = $1, price = $2, quantity = $3, options = $4 WHERE id = $5",
+		variant.SKU, variant.PriceOverride, variant.Quantity, options, variant.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update variant: %w", err)
+	}
+
+	if err := recordStockChange(tx, variant.ProductID, variant.ID, variant.Quantity-quantity, types.InventoryReasonManualAdjust); err != nil {
+		return err
+	}
+
+	if err := syncProductQuantities(tx, []int{variant.ProductID}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) DeleteVariant(variant types.ProductVariant) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the stock leaves with the variant
+	quantity, err := lockVariantQuantity(tx, variant.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := recordStockChange(tx, variant.ProductID, variant.ID, -quantity, types.InventoryReasonManualAdjust); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM product_variants WHERE id = $1", variant.ID); err != nil {
+		return err
+	}
+
+	if err := syncProductQuantities(tx, []int{variant.ProductID}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// syncProductQuantities recomputes products.quantity as the total stock of
+// each product's variants.
+func syncProductQuantities(tx *sql.Tx, productIDs []int) error {
+	query := `
+		UPDATE products
+		SET quantity = (SELECT COALESCE(SUM(quantity), 0) FROM product_variants WHERE productId = products.id)
+		WHERE id = ANY($1);
+	`
+
+	if _, err := tx.Exec(query, pq.Array(productIDs)); err != nil {
+		return fmt.Errorf("failed to sync product quantities: %w", err)
+	}
+
+	return nil
+}
+
+func attachVariants(q querier, products []types.Product, lock bool) error {
+	ids := make([]int, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+
+	variants, err := getVariantsByProductIDs(q, ids, lock)
+	if err != nil {
+		return err
+	}
+
+	for i := range products {
+		products[i].Variants = variants[products[i].ID]
+	}
+
+	return nil
+}
+
+// getVariantsByProductIDs groups the variants of the given products by
+// product ID. With lock set the variant rows are locked FOR UPDATE, which
+// requires q to be a transaction.
+func getVariantsByProductIDs(q querier, productIDs []int, lock bool) (map[int][]types.ProductVariant, error) {
+	query := `
+			SELECT ` + variantColumns + `
+			FROM product_variants v
+			JOIN products p ON p.id = v.productId
+			WHERE v.productId = ANY($1)
+			ORDER BY v.productId, v.id`
+	if lock {
+		query += " FOR UPDATE OF v"
+	}
+
+	rows, err := q.Query(query, pq.Array(productIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch variants: %w", err)
+	}
+	defer rows.Close()
+
+	var scanned []*types.ProductVariant
+	for rows.Next() {
+		v, err := scanRowsIntoVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		scanned = append(scanned, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	rows.Close()
+
+	if err := attachReservedQuantities(q, scanned); err != nil {
+		return nil, err
+	}
+
+	variants := make(map[int][]types.ProductVariant)
+	for _, v := range scanned {
+		variants[v.ProductID] = append(variants[v.ProductID], *v)
+	}
+
+	return variants, nil
+}
+
+func scanRowsIntoVariant(rows *sql.Rows) (*types.ProductVariant, error) {
+	variant := new(types.ProductVariant)
+
+	var options []byte
+	err := rows.Scan(
+		&variant.ID,
+		&variant.ProductID,
+		&variant.SKU,
+		&variant.Price,
+		&variant.PriceOverride,
+		&variant.Quantity,
+		&options,
+		&variant.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(options, &variant.Options); err != nil {
+		return nil, fmt.Errorf("invalid options for variant %d: %w", variant.ID, err)
+	}
+
+	return variant, nil
+}
@@ -0,0 +1,197 @@
+package promotion
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+// Apply checks that the promotion can be used on the checkout and works out
+// its discount. Usage limits are checked separately with CheckUsageLimits,
+// under the promotion's row lock.
+func Apply(p *types.Promotion, items []types.CartCheckoutItem, products map[int]types.Product, shipping types.Money, now time.Time) (*types.PromotionDiscount, error) {
+	if err := checkAvailable(p, now); err != nil {
+		return nil, err
+	}
+
+	lineTotals := make([]types.Money, len(items))
+	subtotal := types.NewMoney(0, "")
+	for i, item := range items {
+		variant, ok := products[item.ProductID].FindVariant(item.VariantID)
+		if !ok {
+			return nil, fmt.Errorf("variant %d not found for product %d", item.VariantID, item.ProductID)
+		}
+
+		lineTotal, err := variant.Price.Mul(item.Quantity)
+		if err != nil {
+			return nil, err
+		}
+
+		lineTotals[i] = lineTotal
+		if subtotal, err = subtotal.Add(lineTotal); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.MinOrderValue != nil {
+		cmp, err := subtotal.Cmp(*p.MinOrderValue)
+		if err != nil {
+			return nil, err
+		}
+		if cmp < 0 {
+			return nil, fmt.Errorf("promotion %s requires an order of at least %s", p.Code, p.MinOrderValue)
+		}
+	}
+
+	discount := &types.PromotionDiscount{
+		Discount:         types.NewMoney(0, ""),
+		ShippingDiscount: types.NewMoney(0, ""),
+		ItemDiscounts:    make([]types.Money, len(items)),
+	}
+	for i := range discount.ItemDiscounts {
+		discount.ItemDiscounts[i] = types.NewMoney(0, "")
+	}
+
+	eligible := false
+	switch p.Type {
+	case types.PromotionPercentage:
+		for i, item := range items {
+			if !appliesTo(p, item.ProductID) {
+				continue
+			}
+
+			eligible = true
+			discount.ItemDiscounts[i] = types.NewMoney(share(lineTotals[i].Amount, int64(p.Percentage), 100), "")
+		}
+
+	case types.PromotionFixedAmount:
+		if p.Amount == nil {
+			return nil, fmt.Errorf("promotion %s has no amount", p.Code)
+		}
+
+		// only the items the promotion applies to are discounted
+		var lines []int
+		eligibleTotal := int64(0)
+		for i, item := range items {
+			if appliesTo(p, item.ProductID) {
+				lines = append(lines, i)
+				eligibleTotal += lineTotals[i].Amount
+			}
+		}
+		if len(lines) == 0 {
+			break
+		}
+
+		eligible = true
+		amount := min(p.Amount.Amount, eligibleTotal)
+
+		// spread the amount over the items in proportion to their totals, the
+		// last item takes whatever rounding leaves over
+		remaining := amount
+		for n, i := range lines {
+			itemDiscount := share(amount, lineTotals[i].Amount, eligibleTotal)
+			if n == len(lines)-1 {
+				itemDiscount = remaining
+			}
+
+			discount.ItemDiscounts[i] = types.NewMoney(itemDiscount, "")
+			remaining -= itemDiscount
+		}
+
+	case types.PromotionBuyXGetY:
+		// every BuyQuantity+GetQuantity units of an item get GetQuantity free
+		for i, item := range items {
+			if !appliesTo(p, item.ProductID) {
+				continue
+			}
+
+			eligible = true
+			free := item.Quantity / (p.BuyQuantity + p.GetQuantity) * p.GetQuantity
+			if free == 0 {
+				continue
+			}
+
+			variant, _ := products[item.ProductID].FindVariant(item.VariantID)
+			itemDiscount, err := variant.Price.Mul(free)
+			if err != nil {
+				return nil, err
+			}
+
+			discount.ItemDiscounts[i] = itemDiscount
+		}
+
+	case types.PromotionFreeShipping:
+		// shipping is free when the cart has one of the promotion's products
+		for _, item := range items {
+			if appliesTo(p, item.ProductID) {
+				eligible = true
+				discount.ShippingDiscount = shipping
+				break
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown promotion type %s", p.Type)
+	}
+
+	if !eligible {
+		return nil, fmt.Errorf("promotion %s doesn't apply to any item in the cart", p.Code)
+	}
+
+	for _, itemDiscount := range discount.ItemDiscounts {
+		var err error
+		if discount.Discount, err = discount.Discount.Add(itemDiscount); err != nil {
+			return nil, err
+		}
+	}
+
+	return discount, nil
+}
+
+// CheckUsageLimits rejects a promotion that has been used up, globally or by
+// the user with userRedemptions uses of it.
+func CheckUsageLimits(p *types.Promotion, userRedemptions int) error {
+	if p.UsageLimit != nil && p.TimesUsed >= *p.UsageLimit {
+		return fmt.Errorf("promotion %s has been used up", p.Code)
+	}
+
+	if p.PerUserLimit != nil && userRedemptions >= *p.PerUserLimit {
+		return fmt.Errorf("promotion %s has already been used the maximum number of times", p.Code)
+	}
+
+	return nil
+}
+
+func checkAvailable(p *types.Promotion, now time.Time) error {
+	if !p.Active {
+		return fmt.Errorf("promotion %s is not active", p.Code)
+	}
+
+	if p.StartsAt != nil && now.Before(*p.StartsAt) {
+		return fmt.Errorf("promotion %s is not valid yet", p.Code)
+	}
+
+	if p.EndsAt != nil && !now.Before(*p.EndsAt) {
+		return fmt.Errorf("promotion %s has expired", p.Code)
+	}
+
+	return nil
+}
+
+func appliesTo(p *types.Promotion, productID int) bool {
+	return len(p.ProductIDs) == 0 || slices.Contains(p.ProductIDs, productID)
+}
+
+// share returns amount*numerator/denominator rounded down, without
+// overflowing on large amounts.
+func share(amount, numerator, denominator int64) int64 {
+	if denominator == 0 {
+		return 0
+	}
+
+	n := new(big.Int).Mul(big.NewInt(amount), big.NewInt(numerator))
+	return n.Quo(n, big.NewInt(denominator)).Int64()
+}
@@ -0,0 +1,291 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/duziem/ecommerce_proj/configs"
+	"github.com/duziem/ecommerce_proj/services/auth"
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/duziem/ecommerce_proj/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	store        types.PromotionStore
+	productStore types.ProductStore
+	userStore    types.UserStore
+}
+
+func NewHandler(store types.PromotionStore, productStore types.ProductStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, productStore: productStore, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// admin routes
+	// get all promotions
+	router.HandleFunc("/admin/promotions", auth.WithJWTAuth(auth.RequirePermission(types.PermissionPromotionsRead, h.handleGetPromotions, h.userStore), h.userStore)).Methods(http.MethodGet)
+	// create a promotion
+	router.HandleFunc("/admin/promotions", auth.WithJWTAuth(auth.RequirePermission(types.PermissionPromotionsWrite, h.handleCreatePromotion, h.userStore), h.userStore)).Methods(http.MethodPost)
+	// get a promotion
+	router.HandleFunc("/admin/promotions/{promotionID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionPromotionsRead, h.handleGetPromotion, h.userStore), h.userStore)).Methods(http.MethodGet)
+	// update a promotion, set active to false to stop it being used
+	router.HandleFunc("/admin/promotions/{promotionID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionPromotionsWrite, h.handleUpdatePromotion, h.userStore), h.userStore)).Methods(http.MethodPatch)
+	// delete a promotion that has never been redeemed
+	router.HandleFunc("/admin/promotions/{promotionID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionPromotionsWrite, h.handleDeletePromotion, h.userStore), h.userStore)).Methods(http.MethodDelete)
+}
+
+func (h *Handler) handleGetPromotions(w http.ResponseWriter, r *http.Request) {
+	promotions, err := h.store.GetPromotions()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, promotions)
+}
+
+func (h *Handler) handleGetPromotion(w http.ResponseWriter, r *http.Request) {
+	promotionID, err := getPromotionID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	promotion, err := h.store.GetPromotionByID(promotionID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, promotion)
+}
+
+func (h *Handler) handleCreatePromotion(w http.ResponseWriter, r *http.Request) {
+	var payload types.CreatePromotionPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	promotion := types.Promotion{
+		Code:          strings.ToUpper(strings.TrimSpace(payload.Code)),
+		Description:   payload.Description,
+		Type:          payload.Type,
+		Percentage:    payload.Percentage,
+		Amount:        payload.Amount,
+		BuyQuantity:   payload.BuyQuantity,
+		GetQuantity:   payload.GetQuantity,
+		ProductIDs:    payload.ProductIDs,
+		MinOrderValue: payload.MinOrderValue,
+		UsageLimit:    payload.UsageLimit,
+		PerUserLimit:  payload.PerUserLimit,
+		StartsAt:      payload.StartsAt,
+		EndsAt:        payload.EndsAt,
+		Active:        payload.Active == nil || *payload.Active,
+	}
+
+	if err := h.validatePromotion(promotion); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// check if the code is taken
+	if _, err := h.store.GetPromotionByCode(promotion.Code); err == nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("promotion with code %s already exists", promotion.Code))
+		return
+	}
+
+	id, err := h.store.CreatePromotion(promotion)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	created, err := h.store.GetPromotionByID(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handler) handleUpdatePromotion(w http.ResponseWriter, r *http.Request) {
+	var payload types.UpdatePromotionPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	promotionID, err := getPromotionID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	promotion, err := h.store.GetPromotionByID(promotionID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	// Update only provided fields
+	if payload.Description != nil {
+		promotion.Description = *payload.Description
+	}
+	if payload.Percentage != nil {
+		promotion.Percentage = *payload.Percentage
+	}
+	if payload.Amount != nil {
+		promotion.Amount = payload.Amount
+	}
+	if payload.BuyQuantity != nil {
+		promotion.BuyQuantity = *payload.BuyQuantity
+	}
+	if payload.GetQuantity != nil {
+		promotion.GetQuantity = *payload.GetQuantity
+	}
+	if payload.ProductIDs != nil {
+		promotion.ProductIDs = payload.ProductIDs
+	}
+	if payload.MinOrderValue != nil {
+		promotion.MinOrderValue = payload.MinOrderValue
+		if payload.MinOrderValue.IsZero() {
+			promotion.MinOrderValue = nil
+		}
+	}
+	if payload.UsageLimit != nil {
+		promotion.UsageLimit = nilIfZero(payload.UsageLimit)
+	}
+	if payload.PerUserLimit != nil {
+		promotion.PerUserLimit = nilIfZero(payload.PerUserLimit)
+	}
+	if payload.StartsAt != nil {
+		promotion.StartsAt = payload.StartsAt
+	}
+	if payload.EndsAt != nil {
+		promotion.EndsAt = payload.EndsAt
+	}
+	if payload.Active != nil {
+		promotion.Active = *payload.Active
+	}
+
+	if err := h.validatePromotion(*promotion); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.UpdatePromotion(*promotion); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, promotion)
+}
+
+func (h *Handler) handleDeletePromotion(w http.ResponseWriter, r *http.Request) {
+	promotionID, err := getPromotionID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := h.store.GetPromotionByID(promotionID); err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	err = h.store.DeletePromotion(promotionID)
+	if errors.Is(err, ErrPromotionRedeemed) {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "promotion deleted successfully"})
+}
+
+// validatePromotion checks the fields required by the promotion's type.
+func (h *Handler) validatePromotion(p types.Promotion) error {
+	switch p.Type {
+	case types.PromotionPercentage:
+		if p.Percentage < 1 || p.Percentage > 100 {
+			return fmt.Errorf("percentage must be between 1 and 100")
+		}
+	case types.PromotionFixedAmount:
+		if p.Amount == nil {
+			return fmt.Errorf("amount is required")
+		}
+	case types.PromotionBuyXGetY:
+		if p.BuyQuantity < 1 || p.GetQuantity < 1 {
+			return fmt.Errorf("buyQuantity and getQuantity are required")
+		}
+	}
+
+	for _, price := range []*types.Money{p.Amount, p.MinOrderValue} {
+		if price != nil && price.Currency != configs.Envs.Currency {
+			return fmt.Errorf("amounts must be in %s", configs.Envs.Currency)
+		}
+	}
+
+	if p.StartsAt != nil && p.EndsAt != nil && !p.StartsAt.Before(*p.EndsAt) {
+		return fmt.Errorf("startsAt must be before endsAt")
+	}
+
+	if len(p.ProductIDs) > 0 {
+		ids := slices.Compact(slices.Sorted(slices.Values(p.ProductIDs)))
+		products, err := h.productStore.GetProductsByID(ids)
+		if err != nil {
+			return err
+		}
+		if len(products) != len(ids) {
+			return fmt.Errorf("one or more products not found")
+		}
+	}
+
+	return nil
+}
+
+func nilIfZero(v *int) *int {
+	if *v == 0 {
+		return nil
+	}
+
+	return v
+}
+
+func getPromotionID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	str, ok := vars["promotionID"]
+	if !ok {
+		return 0, fmt.Errorf("missing promotion ID")
+	}
+
+	promotionID, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid promotion ID")
+	}
+
+	return promotionID, nil
+}
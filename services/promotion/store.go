@@ -0,0 +1,234 @@
+package promotion
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/lib/pq"
+)
+
+// promotionColumns lists the columns read by scanRowsIntoPromotion, in scan order.
+const promotionColumns = `id, code, description, type, COALESCE(percentage, 0), amount, COALESCE(buyQuantity, 0), COALESCE(getQuantity, 0),
+	productIds, minOrderValue, usageLimit, perUserLimit, timesUsed, startsAt, endsAt, active, createdAt`
+
+var ErrPromotionRedeemed = errors.New("promotion has been redeemed, deactivate it instead")
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetPromotions() ([]*types.Promotion, error) {
+	rows, err := s.db.Query("SELECT " + promotionColumns + " FROM promotions ORDER BY createdAt DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	promotions := make([]*types.Promotion, 0)
+	for rows.Next() {
+		p, err := scanRowsIntoPromotion(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		promotions = append(promotions, p)
+	}
+
+	return promotions, rows.Err()
+}
+
+func (s *Store) GetPromotionByID(id int) (*types.Promotion, error) {
+	rows, err := s.db.Query("SELECT "+promotionColumns+" FROM promotions WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanPromotion(rows)
+}
+
+func (s *Store) GetPromotionByCode(code string) (*types.Promotion, error) {
+	rows, err := s.db.Query("SELECT "+promotionColumns+" FROM promotions WHERE code = UPPER($1)", code)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanPromotion(rows)
+}
+
+// GetPromotionByCodeWithLock locks the promotion until the transaction ends,
+// so concurrent checkouts can't redeem it past its limits.
+func (s *Store) GetPromotionByCodeWithLock(tx *sql.Tx, code string) (*types.Promotion, error) {
+	rows, err := tx.Query("SELECT "+promotionColumns+" FROM promotions WHERE code = UPPER($1) FOR UPDATE", code)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanPromotion(rows)
+}
+
+func (s *Store) CreatePromotion(p types.Promotion) (int, error) {
+	if p.ProductIDs == nil {
+		p.ProductIDs = []int{}
+	}
+
+	query := `
+			INSERT INTO promotions (code, description, type, percentage, amount, buyQuantity, getQuantity, productIds,
+				minOrderValue, usageLimit, perUserLimit, startsAt, endsAt, active)
+			VALUES (UPPER($1), $2, $3, NULLIF($4, 0), $5, NULLIF($6, 0), NULLIF($7, 0), $8, $9, $10, $11, $12, $13, $14)
+			RETURNING id;
+	`
+
+	var id int
+	err := s.db.QueryRow(query, p.Code, p.Description, p.Type, p.Percentage, p.Amount, p.BuyQuantity, p.GetQuantity,
+		pq.Array(p.ProductIDs), p.MinOrderValue, p.UsageLimit, p.PerUserLimit, p.StartsAt, p.EndsAt, p.Active).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create promotion: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *Store) UpdatePromotion(p types.Promotion) error {
+	if p.ProductIDs == nil {
+		p.ProductIDs = []int{}
+	}
+
+	query := `
+			UPDATE promotions
+			SET description = $1, percentage = NULLIF($2, 0), amount = $3, buyQuantity = NULLIF($4, 0), getQuantity = NULLIF($5, 0),
+				productIds = $6, minOrderValue = $7, usageLimit = $8, perUserLimit = $9, startsAt = $10, endsAt = $11, active = $12
+			WHERE id = $13;
+	`
+
+	_, err := s.db.Exec(query, p.Description, p.Percentage, p.Amount, p.BuyQuantity, p.GetQuantity, pq.Array(p.ProductIDs),
+		p.MinOrderValue, p.UsageLimit, p.PerUserLimit, p.StartsAt, p.EndsAt, p.Active, p.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update promotion: %w", err)
+	}
+
+	return nil
+}
+
+// DeletePromotion deletes a promotion that was never redeemed. Redemptions
+// keep pointing at the promotion, so it fails with ErrPromotionRedeemed once
+// there are any.
+func (s *Store) DeletePromotion(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// locking the promotion waits for checkouts that are redeeming it
+	var locked int
+	if err := tx.QueryRow("SELECT id FROM promotions WHERE id = $1 FOR UPDATE", id).Scan(&locked); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("promotion not found")
+		}
+		return err
+	}
+
+	var redeemed bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM promotion_redemptions WHERE promotionId = $1)", id).Scan(&redeemed); err != nil {
+		return err
+	}
+	if redeemed {
+		return ErrPromotionRedeemed
+	}
+
+	if _, err := tx.Exec("DELETE FROM promotions WHERE id = $1", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) CountUserRedemptions(tx *sql.Tx, promotionID, userID int) (int, error) {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM promotion_redemptions WHERE promotionId = $1 AND userId = $2", promotionID, userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count redemptions: %w", err)
+	}
+
+	return count, nil
+}
+
+// RedeemPromotion records the use of a promotion on an order. It must run in
+// the transaction that locked the promotion.
+func (s *Store) RedeemPromotion(tx *sql.Tx, promotionID, userID, orderID int, discount types.Money) error {
+	_, err := tx.Exec("INSERT INTO promotion_redemptions (promotionId, userId, orderId, discount) VALUES ($1, $2, $3, $4)",
+		promotionID, userID, orderID, discount)
+	if err != nil {
+		return fmt.Errorf("failed to record redemption: %w", err)
+	}
+
+	if _, err := tx.Exec("UPDATE promotions SET timesUsed = timesUsed + 1 WHERE id = $1", promotionID); err != nil {
+		return fmt.Errorf("failed to update promotion usage: %w", err)
+	}
+
+	return nil
+}
+
+func scanPromotion(rows *sql.Rows) (*types.Promotion, error) {
+	defer rows.Close()
+
+	p := new(types.Promotion)
+	for rows.Next() {
+		var err error
+		p, err = scanRowsIntoPromotion(rows)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if p.ID == 0 {
+		return nil, fmt.Errorf("promotion not found")
+	}
+
+	return p, nil
+}
+
+func scanRowsIntoPromotion(rows *sql.Rows) (*types.Promotion, error) {
+	p := new(types.Promotion)
+
+	var productIDs pq.Int64Array
+	err := rows.Scan(
+		&p.ID,
+		&p.Code,
+		&p.Description,
+		&p.Type,
+		&p.Percentage,
+		&p.Amount,
+		&p.BuyQuantity,
+		&p.GetQuantity,
+		&productIDs,
+		&p.MinOrderValue,
+		&p.UsageLimit,
+		&p.PerUserLimit,
+		&p.TimesUsed,
+		&p.StartsAt,
+		&p.EndsAt,
+		&p.Active,
+		&p.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	p.ProductIDs = make([]int, len(productIDs))
+	for i, id := range productIDs {
+		p.ProductIDs[i] = int(id)
+	}
+
+	return p, nil
+}
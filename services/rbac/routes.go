@@ -0,0 +1,143 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/duziem/ecommerce_proj/services/auth"
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/duziem/ecommerce_proj/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	store     types.RBACStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.RBACStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// admin routes
+	// get the roles with their permissions
+	router.HandleFunc("/admin/roles", auth.WithJWTAuth(auth.RequirePermission(types.PermissionUsersManage, h.handleGetRoles, h.userStore), h.userStore)).Methods(http.MethodGet)
+	// get the permissions roles can grant
+	router.HandleFunc("/admin/permissions", auth.WithJWTAuth(auth.RequirePermission(types.PermissionUsersManage, h.handleGetPermissions, h.userStore), h.userStore)).Methods(http.MethodGet)
+	// get the roles of a user
+	router.HandleFunc("/admin/users/{userID}/roles", auth.WithJWTAuth(auth.RequirePermission(types.PermissionUsersManage, h.handleGetUserRoles, h.userStore), h.userStore)).Methods(http.MethodGet)
+	// give a user a role
+	router.HandleFunc("/admin/users/{userID}/roles", auth.WithJWTAuth(auth.RequirePermission(types.PermissionUsersManage, h.handleAssignRole, h.userStore), h.userStore)).Methods(http.MethodPost)
+	// take a role away from a user, the last admin keeps the admin role
+	router.HandleFunc("/admin/users/{userID}/roles/{role}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionUsersManage, h.handleRemoveRole, h.userStore), h.userStore)).Methods(http.MethodDelete)
+}
+
+func (h *Handler) handleGetRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.store.GetRoles()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, roles)
+}
+
+func (h *Handler) handleGetPermissions(w http.ResponseWriter, r *http.Request) {
+	permissions, err := h.store.GetPermissions()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, permissions)
+}
+
+func (h *Handler) handleGetUserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.getUserID(w, r)
+	if err != nil {
+		return
+	}
+
+	roles, err := h.store.GetUserRoles(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, roles)
+}
+
+func (h *Handler) handleAssignRole(w http.ResponseWriter, r *http.Request) {
+	var payload types.AssignRolePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	userID, err := h.getUserID(w, r)
+	if err != nil {
+		return
+	}
+
+	err = h.store.AssignUserRole(userID, payload.Role)
+	if errors.Is(err, ErrRoleNotFound) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "role assigned successfully"})
+}
+
+func (h *Handler) handleRemoveRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.getUserID(w, r)
+	if err != nil {
+		return
+	}
+
+	err = h.store.RemoveUserRole(userID, mux.Vars(r)["role"])
+	switch {
+	case errors.Is(err, ErrRoleNotFound), errors.Is(err, ErrRoleNotAssigned):
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, ErrLastAdmin):
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "role removed successfully"})
+}
+
+// getUserID reads the user ID from the path and checks the user exists,
+// writing an error response when it doesn't.
+func (h *Handler) getUserID(w http.ResponseWriter, r *http.Request) (int, error) {
+	userID, err := strconv.Atoi(mux.Vars(r)["userID"])
+	if err != nil {
+		err = fmt.Errorf("invalid user ID")
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return 0, err
+	}
+
+	if _, err := h.userStore.GetUserByID(userID); err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return 0, err
+	}
+
+	return userID, nil
+}
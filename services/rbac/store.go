@@ -0,0 +1,150 @@
+package rbac
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrRoleNotFound    = errors.New("role not found")
+	ErrRoleNotAssigned = errors.New("the user doesn't have this role")
+	// ErrLastAdmin keeps the store from being left without anyone who can
+	// manage roles
+	ErrLastAdmin = errors.New("the last admin can't lose the admin role")
+)
+
+// roleColumns lists the columns read by scanRowsIntoRole, in scan order
+const roleColumns = `r.id, r.name, r.description, r.createdAt,
+	ARRAY(SELECT p.name FROM role_permissions rp JOIN permissions p ON p.id = rp.permissionId WHERE rp.roleId = r.id ORDER BY p.name)`
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetRoles() ([]types.Role, error) {
+	rows, err := s.db.Query("SELECT " + roleColumns + " FROM roles r ORDER BY r.name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRoles(rows)
+}
+
+func (s *Store) GetPermissions() ([]types.Permission, error) {
+	rows, err := s.db.Query("SELECT id, name, description FROM permissions ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []types.Permission{}
+	for rows.Next() {
+		var p types.Permission
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description); err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, p)
+	}
+
+	return permissions, rows.Err()
+}
+
+func (s *Store) GetUserRoles(userID int) ([]types.Role, error) {
+	rows, err := s.db.Query("SELECT "+roleColumns+" FROM roles r JOIN user_roles ur ON ur.roleId = r.id WHERE ur.userId = $1 ORDER BY r.name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRoles(rows)
+}
+
+// AssignUserRole gives the user the role, it is a no-op when they already
+// have it.
+func (s *Store) AssignUserRole(userID int, role string) error {
+	roleID, err := getRoleID(s.db, role)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("INSERT INTO user_roles (userId, roleId) VALUES ($1, $2) ON CONFLICT DO NOTHING", userID, roleID)
+	return err
+}
+
+func (s *Store) RemoveUserRole(userID int, role string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	roleID, err := getRoleID(tx, role)
+	if err != nil {
+		return err
+	}
+
+	// admins are counted under a lock on the role, so two admins can't
+	// remove each other at the same time
+	if role == types.RoleAdmin {
+		if _, err := tx.Exec("SELECT id FROM roles WHERE id = $1 FOR UPDATE", roleID); err != nil {
+			return err
+		}
+
+		var admins int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM user_roles WHERE roleId = $1 AND userId <> $2", roleID, userID).Scan(&admins); err != nil {
+			return err
+		}
+		if admins == 0 {
+			return ErrLastAdmin
+		}
+	}
+
+	res, err := tx.Exec("DELETE FROM user_roles WHERE userId = $1 AND roleId = $2", userID, roleID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRoleNotAssigned
+	}
+
+	return tx.Commit()
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func getRoleID(q queryRower, role string) (int, error) {
+	var id int
+	err := q.QueryRow("SELECT id FROM roles WHERE name = $1", role).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %s", ErrRoleNotFound, role)
+	}
+
+	return id, err
+}
+
+func scanRoles(rows *sql.Rows) ([]types.Role, error) {
+	roles := []types.Role{}
+	for rows.Next() {
+		var r types.Role
+		if err := rows.Scan(&r.ID, &r.Name, &r.Description, &r.CreatedAt, pq.Array(&r.Permissions)); err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, r)
+	}
+
+	return roles, rows.Err()
+}
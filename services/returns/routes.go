@@ -0,0 +1,519 @@
+package returns
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/duziem/ecommerce_proj/services/auth"
+	"github.com/duziem/ecommerce_proj/services/order"
+	"github.com/duziem/ecommerce_proj/services/payments"
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/duziem/ecommerce_proj/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	store        types.ReturnStore
+	orderStore   types.OrderStore
+	productStore types.ProductStore
+	userStore    types.UserStore
+	payments     *payments.Service
+}
+
+func NewHandler(store types.ReturnStore, orderStore types.OrderStore, productStore types.ProductStore, userStore types.UserStore, payments *payments.Service) *Handler {
+	return &Handler{store: store, orderStore: orderStore, productStore: productStore, userStore: userStore, payments: payments}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// request a return of some of an order's items
+	router.HandleFunc("/orders/{orderID}/returns", auth.WithJWTAuth(h.handleCreateReturn, h.userStore)).Methods(http.MethodPost)
+	// get the returns of an order
+	router.HandleFunc("/orders/{orderID}/returns", auth.WithJWTAuth(h.handleGetOrderReturns, h.userStore)).Methods(http.MethodGet)
+
+	// admin routes
+	// get all returns, or only those with ?status=
+	router.HandleFunc("/admin/returns", auth.WithJWTAuth(auth.RequirePermission(types.PermissionOrdersRead, h.handleGetReturns, h.userStore), h.userStore)).Methods(http.MethodGet)
+	// get a return
+	router.HandleFunc("/admin/returns/{returnID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionOrdersRead, h.handleGetReturn, h.userStore), h.userStore)).Methods(http.MethodGet)
+	// approve a requested return and refund it, or retry a refund that failed
+	router.HandleFunc("/admin/returns/{returnID}/approve", auth.WithJWTAuth(auth.RequirePermission(types.PermissionOrdersRefund, h.handleApproveReturn, h.userStore), h.userStore)).Methods(http.MethodPost)
+	// reject a requested return
+	router.HandleFunc("/admin/returns/{returnID}/reject", auth.WithJWTAuth(auth.RequirePermission(types.PermissionOrdersWrite, h.handleRejectReturn, h.userStore), h.userStore)).Methods(http.MethodPost)
+	// mark the items of an approved return as received, optionally restocking them
+	router.HandleFunc("/admin/returns/{returnID}/receive", auth.WithJWTAuth(auth.RequirePermission(types.PermissionOrdersWrite, h.handleReceiveReturn, h.userStore), h.userStore)).Methods(http.MethodPost)
+}
+
+func (h *Handler) handleCreateReturn(w http.ResponseWriter, r *http.Request) {
+	var payload types.CreateReturnPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	orderID, err := getRouteID(r, "orderID", "order")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	tx, err := h.productStore.BeginTransaction()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to start transaction: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the order so concurrent requests can't return the same items twice
+	o, err := h.orderStore.GetOrderByIDWithLock(tx, orderID)
+	if err != nil || o.UserID != userID {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order not found"))
+		return
+	}
+
+	if !o.Status.CanTransitionTo(types.OrderStatusRefunded) {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("a %s order cannot be returned", o.Status))
+		return
+	}
+
+	orderItems, err := h.orderStore.GetOrderItemsTx(tx, o.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// items in returns that haven't been rejected can't be returned again
+	returned, err := h.store.GetReturnedQuantities(tx, o.ID, []types.ReturnStatus{
+		types.ReturnStatusRequested, types.ReturnStatusApproved, types.ReturnStatusReceived,
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	items, refundAmount, err := buildReturnItems(payload.Items, orderItems, returned)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	returnID, err := h.store.CreateReturn(tx, types.Return{
+		OrderID:      o.ID,
+		UserID:       userID,
+		Status:       types.ReturnStatusRequested,
+		Reason:       payload.Reason,
+		RefundAmount: refundAmount,
+		Items:        items,
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to commit transaction: %v", err))
+		return
+	}
+
+	h.writeReturn(w, http.StatusCreated, returnID)
+}
+
+func (h *Handler) handleGetOrderReturns(w http.ResponseWriter, r *http.Request) {
+	orderID, err := getRouteID(r, "orderID", "order")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	o, err := h.orderStore.GetOrderByID(orderID)
+	if err != nil || o.UserID != auth.GetUserIDFromContext(r.Context()) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order not found"))
+		return
+	}
+
+	returns, err := h.store.GetReturnsByOrderID(o.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, returns)
+}
+
+func (h *Handler) handleGetReturns(w http.ResponseWriter, r *http.Request) {
+	status := types.ReturnStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "", types.ReturnStatusRequested, types.ReturnStatusApproved, types.ReturnStatusRejected, types.ReturnStatusReceived:
+	default:
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid status %s", status))
+		return
+	}
+
+	returns, err := h.store.GetReturns(status)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, returns)
+}
+
+func (h *Handler) handleGetReturn(w http.ResponseWriter, r *http.Request) {
+	returnID, err := getRouteID(r, "returnID", "return")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ret, err := h.store.GetReturnByID(returnID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, ret)
+}
+
+func (h *Handler) handleApproveReturn(w http.ResponseWriter, r *http.Request) {
+	var payload types.ReviewReturnPayload
+	if !parsePayload(w, r, &payload) {
+		return
+	}
+
+	returnID, err := getRouteID(r, "returnID", "return")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tx, err := h.productStore.BeginTransaction()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to start transaction: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	ret, err := h.store.GetReturnByIDWithLock(tx, returnID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	// approving a return whose refund failed retries the refund
+	if ret.Status == types.ReturnStatusApproved || ret.Status == types.ReturnStatusReceived {
+		if refund, err := h.store.GetRefundByReturnID(ret.ID); err == nil && refund.Status == types.RefundStatusPending {
+			tx.Rollback()
+			h.refundReturn(w, r, ret.ID)
+			return
+		}
+	}
+
+	if ret.Status != types.ReturnStatusRequested {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("cannot approve a %s return", ret.Status))
+		return
+	}
+
+	o, err := h.orderStore.GetOrderByIDWithLock(tx, ret.OrderID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	orderItems, err := h.orderStore.GetOrderItemsTx(tx, o.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// the order is refunded in full once every item has been refunded
+	refunded, err := h.store.GetReturnedQuantities(tx, o.ID, []types.ReturnStatus{
+		types.ReturnStatusApproved, types.ReturnStatusReceived,
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, item := range ret.Items {
+		refunded[item.OrderItemID] += item.Quantity
+	}
+
+	status := refundedStatus(orderItems, refunded)
+	if !o.Status.CanTransitionTo(status) {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("cannot change order status from %s to %s", o.Status, status))
+		return
+	}
+
+	// the refund is only recorded here, the provider gives the money back
+	// once the approval is committed
+	refund := types.Refund{OrderID: o.ID, ReturnID: &ret.ID, Amount: ret.RefundAmount, Status: types.RefundStatusPending}
+	if _, err := h.store.CreateRefund(tx, refund); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ret.Status = types.ReturnStatusApproved
+	ret.AdminNote = payload.Note
+	if err := h.store.UpdateReturn(tx, *ret); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = h.orderStore.UpdateOrderStatusTx(tx, o, status)
+	if errors.Is(err, order.ErrOrderStatusChanged) {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to commit transaction: %v", err))
+		return
+	}
+
+	h.refundReturn(w, r, ret.ID)
+}
+
+// refundReturn gives back the pending refund of an approved return and
+// writes the return. When that fails the refund stays pending, so approving
+// the return again retries it.
+func (h *Handler) refundReturn(w http.ResponseWriter, r *http.Request, returnID int) {
+	if err := h.refund(r.Context(), returnID); err != nil {
+		utils.WriteError(w, http.StatusBadGateway, fmt.Errorf("return was approved but the refund failed, approve it again to retry: %v", err))
+		return
+	}
+
+	h.writeReturn(w, http.StatusOK, returnID)
+}
+
+// refund gives back the return's refund through the payment provider. The
+// return is the idempotency key, so the provider refunds it only once however
+// many times it is retried.
+func (h *Handler) refund(ctx context.Context, returnID int) error {
+	tx, err := h.productStore.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	refund, err := h.store.GetRefundByReturnIDWithLock(tx, returnID)
+	if err != nil {
+		return err
+	}
+
+	if refund.Status == types.RefundStatusCompleted {
+		return nil
+	}
+
+	key := fmt.Sprintf("return-%d", returnID)
+	if err := h.payments.Refund(ctx, tx, refund.OrderID, refund.Amount, key); err != nil {
+		// release the refund before recording the error on it
+		tx.Rollback()
+		if serr := h.store.SetRefundError(refund.ID, err.Error()); serr != nil {
+			log.Printf("failed to record the error of refund %d: %v", refund.ID, serr)
+		}
+		return err
+	}
+
+	if err := h.store.CompleteRefund(tx, refund.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (h *Handler) handleRejectReturn(w http.ResponseWriter, r *http.Request) {
+	var payload types.ReviewReturnPayload
+	if !parsePayload(w, r, &payload) {
+		return
+	}
+
+	returnID, err := getRouteID(r, "returnID", "return")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tx, err := h.productStore.BeginTransaction()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to start transaction: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	ret, err := h.store.GetReturnByIDWithLock(tx, returnID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if ret.Status != types.ReturnStatusRequested {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("cannot reject a %s return", ret.Status))
+		return
+	}
+
+	ret.Status = types.ReturnStatusRejected
+	ret.AdminNote = payload.Note
+	if err := h.store.UpdateReturn(tx, *ret); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to commit transaction: %v", err))
+		return
+	}
+
+	h.writeReturn(w, http.StatusOK, ret.ID)
+}
+
+func (h *Handler) handleReceiveReturn(w http.ResponseWriter, r *http.Request) {
+	var payload types.ReceiveReturnPayload
+	if !parsePayload(w, r, &payload) {
+		return
+	}
+
+	returnID, err := getRouteID(r, "returnID", "return")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tx, err := h.productStore.BeginTransaction()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to start transaction: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	ret, err := h.store.GetReturnByIDWithLock(tx, returnID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if ret.Status != types.ReturnStatusApproved {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("cannot receive a %s return", ret.Status))
+		return
+	}
+
+	if payload.Restock {
+		if err := h.restock(tx, ret); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	ret.Status = types.ReturnStatusReceived
+	ret.Restocked = payload.Restock
+	if payload.Note != "" {
+		ret.AdminNote = payload.Note
+	}
+	if err := h.store.UpdateReturn(tx, *ret); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to commit transaction: %v", err))
+		return
+	}
+
+	h.writeReturn(w, http.StatusOK, ret.ID)
+}
+
+// restock puts the returned items back in stock. Items whose variant has
+// since been deleted are skipped.
+func (h *Handler) restock(tx *sql.Tx, ret *types.Return) error {
+	var items []types.CartCheckoutItem
+	var movements []types.InventoryMovement
+	var productIDs []int
+	for _, item := range ret.Items {
+		if item.VariantID == nil {
+			continue
+		}
+
+		items = append(items, types.CartCheckoutItem{ProductID: item.ProductID, VariantID: *item.VariantID, Quantity: item.Quantity})
+		movements = append(movements, types.InventoryMovement{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			OrderID:   &ret.OrderID,
+			Quantity:  item.Quantity,
+			Reason:    types.InventoryReasonReturn,
+		})
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	if _, err := h.productStore.GetProductsByIDWithLock(tx, productIDs); err != nil {
+		return fmt.Errorf("failed to fetch products: %v", err)
+	}
+
+	if err := h.productStore.RestockProducts(tx, items); err != nil {
+		return err
+	}
+
+	return h.productStore.RecordInventoryMovements(tx, movements)
+}
+
+func (h *Handler) writeReturn(w http.ResponseWriter, status int, returnID int) {
+	ret, err := h.store.GetReturnByID(returnID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, status, ret)
+}
+
+// parsePayload parses and validates an optional JSON body, an empty body
+// leaves payload as it is.
+func parsePayload(w http.ResponseWriter, r *http.Request, payload interface{}) bool {
+	if r.ContentLength != 0 {
+		if err := utils.ParseJSON(r, payload); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return false
+		}
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return false
+	}
+
+	return true
+}
+
+func getRouteID(r *http.Request, name, resource string) (int, error) {
+	vars := mux.Vars(r)
+	str, ok := vars[name]
+	if !ok {
+		return 0, fmt.Errorf("missing %s ID", resource)
+	}
+
+	id, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s ID", resource)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,93 @@
+package returns
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+// buildReturnItems checks the requested quantities against what is left to
+// return of each order item, and works out their refunds. An item is refunded
+// at what was paid for it, its share of the order discount taken off.
+func buildReturnItems(requested []types.ReturnItemPayload, orderItems []types.OrderItem, returned map[int]int) ([]types.ReturnItem, types.Money, error) {
+	itemsByID := make(map[int]types.OrderItem, len(orderItems))
+	for _, item := range orderItems {
+		itemsByID[item.ID] = item
+	}
+
+	total := types.NewMoney(0, "")
+	items := make([]types.ReturnItem, 0, len(requested))
+	positions := make(map[int]int)
+	for _, r := range requested {
+		orderItem, ok := itemsByID[r.OrderItemID]
+		if !ok {
+			return nil, types.Money{}, fmt.Errorf("item %d is not part of this order", r.OrderItemID)
+		}
+
+		// the same item listed twice is returned once with both quantities
+		if i, ok := positions[r.OrderItemID]; ok {
+			items[i].Quantity += r.Quantity
+			continue
+		}
+
+		positions[r.OrderItemID] = len(items)
+		items = append(items, types.ReturnItem{
+			OrderItemID: orderItem.ID,
+			ProductID:   orderItem.ProductID,
+			VariantID:   orderItem.VariantID,
+			Quantity:    r.Quantity,
+		})
+	}
+
+	for i, item := range items {
+		orderItem := itemsByID[item.OrderItemID]
+
+		remaining := orderItem.Quantity - returned[orderItem.ID]
+		if item.Quantity > remaining {
+			return nil, types.Money{}, fmt.Errorf("only %d of item %d can be returned", max(remaining, 0), orderItem.ID)
+		}
+
+		refund, err := refundAmount(orderItem, item.Quantity)
+		if err != nil {
+			return nil, types.Money{}, err
+		}
+
+		items[i].RefundAmount = refund
+		if total, err = total.Add(refund); err != nil {
+			return nil, types.Money{}, err
+		}
+	}
+
+	return items, total, nil
+}
+
+// refundAmount is what was paid for quantity units of the item, rounded down.
+func refundAmount(item types.OrderItem, quantity int) (types.Money, error) {
+	lineTotal, err := item.Price.Mul(item.Quantity)
+	if err != nil {
+		return types.Money{}, err
+	}
+
+	paid, err := lineTotal.Sub(item.Discount)
+	if err != nil {
+		return types.Money{}, err
+	}
+
+	n := new(big.Int).Mul(big.NewInt(paid.Amount), big.NewInt(int64(quantity)))
+	n.Quo(n, big.NewInt(int64(item.Quantity)))
+
+	return types.NewMoney(n.Int64(), paid.Currency), nil
+}
+
+// refundedStatus is the status of the order once the returned quantities have
+// been refunded: refunded when every item has come back in full.
+func refundedStatus(orderItems []types.OrderItem, refunded map[int]int) types.OrderStatus {
+	for _, item := range orderItems {
+		if refunded[item.ID] < item.Quantity {
+			return types.OrderStatusPartiallyRefunded
+		}
+	}
+
+	return types.OrderStatusRefunded
+}
@@ -0,0 +1,280 @@
+package returns
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/lib/pq"
+)
+
+// returnColumns lists the columns read by scanRowsIntoReturn, in scan order.
+const returnColumns = "id, orderId, userId, status, reason, adminNote, refundAmount, restocked, createdAt, updatedAt"
+
+// refundColumns lists the columns read by scanRowsIntoRefund, in scan order.
+const refundColumns = "id, orderId, returnId, amount, status, lastError, createdAt, completedAt"
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// GetReturns lists returns, newest first, optionally only those with status.
+func (s *Store) GetReturns(status types.ReturnStatus) ([]*types.Return, error) {
+	return getReturns(s.db, "$1 = '' OR status = $1 ORDER BY createdAt DESC, id DESC", status)
+}
+
+func (s *Store) GetReturnByID(id int) (*types.Return, error) {
+	return getReturn(s.db, "id = $1", id)
+}
+
+func (s *Store) GetReturnsByOrderID(orderID int) ([]*types.Return, error) {
+	return getReturns(s.db, "orderId = $1 ORDER BY createdAt, id", orderID)
+}
+
+// GetReturnByIDWithLock locks the return until the transaction ends.
+func (s *Store) GetReturnByIDWithLock(tx *sql.Tx, id int) (*types.Return, error) {
+	return getReturn(tx, "id = $1 FOR UPDATE", id)
+}
+
+// GetReturnedQuantities sums the quantities of each of the order's items in
+// returns with one of the given statuses, keyed by order item ID.
+func (s *Store) GetReturnedQuantities(tx *sql.Tx, orderID int, statuses []types.ReturnStatus) (map[int]int, error) {
+	query := `
+			SELECT ri.orderItemId, SUM(ri.quantity)
+			FROM return_items ri
+			JOIN returns r ON r.id = ri.returnId
+			WHERE r.orderId = $1 AND r.status = ANY($2)
+			GROUP BY ri.orderItemId;
+	`
+
+	names := make([]string, len(statuses))
+	for i, status := range statuses {
+		names[i] = string(status)
+	}
+
+	rows, err := tx.Query(query, orderID, pq.Array(names))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch returned quantities: %w", err)
+	}
+	defer rows.Close()
+
+	quantities := make(map[int]int)
+	for rows.Next() {
+		var orderItemID, quantity int
+		if err := rows.Scan(&orderItemID, &quantity); err != nil {
+			return nil, err
+		}
+
+		quantities[orderItemID] = quantity
+	}
+
+	return quantities, rows.Err()
+}
+
+func (s *Store) CreateReturn(tx *sql.Tx, r types.Return) (int, error) {
+	var id int
+	err := tx.QueryRow("INSERT INTO returns (orderId, userId, status, reason, refundAmount) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		r.OrderID, r.UserID, r.Status, r.Reason, r.RefundAmount).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create return: %w", err)
+	}
+
+	for _, item := range r.Items {
+		_, err := tx.Exec("INSERT INTO return_items (returnId, orderItemId, quantity, refundAmount) VALUES ($1, $2, $3, $4)",
+			id, item.OrderItemID, item.Quantity, item.RefundAmount)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create return item: %w", err)
+		}
+	}
+
+	return id, nil
+}
+
+func (s *Store) UpdateReturn(tx *sql.Tx, r types.Return) error {
+	_, err := tx.Exec("UPDATE returns SET status = $1, adminNote = $2, restocked = $3, updatedAt = NOW() WHERE id = $4",
+		r.Status, r.AdminNote, r.Restocked, r.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update return: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) CreateRefund(tx *sql.Tx, refund types.Refund) (int, error) {
+	var id int
+	err := tx.QueryRow("INSERT INTO refunds (orderId, returnId, amount, status) VALUES ($1, $2, $3, $4) RETURNING id",
+		refund.OrderID, refund.ReturnID, refund.Amount, refund.Status).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create refund: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *Store) GetRefundByReturnID(returnID int) (*types.Refund, error) {
+	return getRefund(s.db, "returnId = $1", returnID)
+}
+
+// GetRefundByReturnIDWithLock locks the refund until the transaction ends,
+// so it is given back by one request at a time.
+func (s *Store) GetRefundByReturnIDWithLock(tx *sql.Tx, returnID int) (*types.Refund, error) {
+	return getRefund(tx, "returnId = $1 FOR UPDATE", returnID)
+}
+
+func (s *Store) CompleteRefund(tx *sql.Tx, id int) error {
+	_, err := tx.Exec("UPDATE refunds SET status = $1, lastError = '', completedAt = NOW() WHERE id = $2", types.RefundStatusCompleted, id)
+	if err != nil {
+		return fmt.Errorf("failed to complete refund: %w", err)
+	}
+
+	return nil
+}
+
+// SetRefundError records why giving back a pending refund failed.
+func (s *Store) SetRefundError(id int, lastError string) error {
+	if _, err := s.db.Exec("UPDATE refunds SET lastError = $1 WHERE id = $2", lastError, id); err != nil {
+		return fmt.Errorf("failed to update refund: %w", err)
+	}
+
+	return nil
+}
+
+func getReturn(q querier, where string, arg interface{}) (*types.Return, error) {
+	returns, err := getReturns(q, where, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(returns) == 0 {
+		return nil, fmt.Errorf("return not found")
+	}
+
+	return returns[0], nil
+}
+
+// getReturns loads the returns matching where, along with their items.
+func getReturns(q querier, where string, arg interface{}) ([]*types.Return, error) {
+	rows, err := q.Query("SELECT "+returnColumns+" FROM returns WHERE "+where, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	returns := make([]*types.Return, 0)
+	byID := make(map[int]*types.Return)
+	ids := make([]int, 0)
+	for rows.Next() {
+		r, err := scanRowsIntoReturn(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		r.Items = []types.ReturnItem{}
+		returns = append(returns, r)
+		byID[r.ID] = r
+		ids = append(ids, r.ID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(ids) == 0 {
+		return returns, nil
+	}
+
+	query := `
+			SELECT ri.id, ri.returnId, ri.orderItemId, oi.productId, oi.variantId, ri.quantity, ri.refundAmount
+			FROM return_items ri
+			JOIN order_items oi ON oi.id = ri.orderItemId
+			WHERE ri.returnId = ANY($1)
+			ORDER BY ri.id;
+	`
+
+	itemRows, err := q.Query(query, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch return items: %w", err)
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		var item types.ReturnItem
+		err := itemRows.Scan(&item.ID, &item.ReturnID, &item.OrderItemID, &item.ProductID, &item.VariantID, &item.Quantity, &item.RefundAmount)
+		if err != nil {
+			return nil, err
+		}
+
+		byID[item.ReturnID].Items = append(byID[item.ReturnID].Items, item)
+	}
+
+	return returns, itemRows.Err()
+}
+
+func scanRowsIntoReturn(rows *sql.Rows) (*types.Return, error) {
+	r := new(types.Return)
+
+	err := rows.Scan(
+		&r.ID,
+		&r.OrderID,
+		&r.UserID,
+		&r.Status,
+		&r.Reason,
+		&r.AdminNote,
+		&r.RefundAmount,
+		&r.Restocked,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func getRefund(q querier, where string, arg interface{}) (*types.Refund, error) {
+	rows, err := q.Query("SELECT "+refundColumns+" FROM refunds WHERE "+where, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("refund not found")
+	}
+
+	return scanRowsIntoRefund(rows)
+}
+
+func scanRowsIntoRefund(rows *sql.Rows) (*types.Refund, error) {
+	refund := new(types.Refund)
+
+	err := rows.Scan(
+		&refund.ID,
+		&refund.OrderID,
+		&refund.ReturnID,
+		&refund.Amount,
+		&refund.Status,
+		&refund.LastError,
+		&refund.CreatedAt,
+		&refund.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return refund, nil
+}
@@ -0,0 +1,216 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/duziem/ecommerce_proj/configs"
+	"github.com/duziem/ecommerce_proj/services/auth"
+	"github.com/duziem/ecommerce_proj/types"
+	"github.com/duziem/ecommerce_proj/utils"
+	"github.com/go-playground/validator/v10"
+)
+
+func (h *Handler) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload types.ForgotPasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	// unknown emails get the same response, so it can't be used to find out
+	// who has an account
+	message := map[string]interface{}{"message": "if the email has an account, a password reset link has been sent to it"}
+
+	u, err := h.store.GetUserByEmail(payload.Email)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusAccepted, message)
+		return
+	}
+
+	tx, err := h.store.BeginTransaction()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	expiresAt := time.Now().Add(time.Second * time.Duration(configs.Envs.PasswordResetTTLInSeconds))
+	if err := h.store.CreateUserToken(tx, u.ID, types.UserTokenPasswordReset, tokenHash, expiresAt); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.mailStore.EnqueueEmail(tx, passwordResetEmail(*u, token)); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, message)
+}
+
+func (h *Handler) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload types.ResetPasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(payload.Password)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	tx, err := h.store.BeginTransaction()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	userID, err := h.store.ConsumeUserToken(tx, types.UserTokenPasswordReset, auth.HashOpaqueToken(payload.Token))
+	if errors.Is(err, ErrInvalidUserToken) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.store.ResetPassword(tx, userID, hashedPassword); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// the reset link proves the user can read the email
+	if err := h.store.MarkEmailVerified(tx, userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "password reset successfully, please log in again"})
+}
+
+func (h *Handler) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var payload types.VerifyEmailPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	tx, err := h.store.BeginTransaction()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	userID, err := h.store.ConsumeUserToken(tx, types.UserTokenEmailVerification, auth.HashOpaqueToken(payload.Token))
+	if errors.Is(err, ErrInvalidUserToken) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.store.MarkEmailVerified(tx, userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "email verified successfully"})
+}
+
+func (h *Handler) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	u, err := h.store.GetUserByID(auth.GetUserIDFromContext(r.Context()))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if u.EmailVerifiedAt != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("email is already verified"))
+		return
+	}
+
+	tx, err := h.store.BeginTransaction()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := h.sendVerificationEmail(tx, *u); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, map[string]interface{}{"message": "verification email sent"})
+}
+
+// sendVerificationEmail adds an email with a new verification token to the
+// outbox, it goes out if tx is committed.
+func (h *Handler) sendVerificationEmail(tx *sql.Tx, u types.User) error {
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(time.Second * time.Duration(configs.Envs.EmailVerifyTTLInSeconds))
+	if err := h.store.CreateUserToken(tx, u.ID, types.UserTokenEmailVerification, tokenHash, expiresAt); err != nil {
+		return err
+	}
+
+	return h.mailStore.EnqueueEmail(tx, verificationEmail(u, token))
+}
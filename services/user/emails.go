@@ -0,0 +1,31 @@
+package user
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/duziem/ecommerce_proj/configs"
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+func verificationEmail(u types.User, token string) types.Email {
+	link := fmt.Sprintf("%s/verify-email?token=%s", configs.Envs.AppURL, url.QueryEscape(token))
+
+	return types.Email{
+		To:      u.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening this link:\n\n%s\n\nIf you didn't create an account, you can ignore this email.\n",
+			u.FirstName, link),
+	}
+}
+
+func passwordResetEmail(u types.User, token string) types.Email {
+	link := fmt.Sprintf("%s/reset-password?token=%s", configs.Envs.AppURL, url.QueryEscape(token))
+
+	return types.Email{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nYou can choose a new password with this link, it works once and expires in %d minutes:\n\n%s\n\nIf you didn't ask for a password reset, you can ignore this email.\n",
+			u.FirstName, configs.Envs.PasswordResetTTLInSeconds/60, link),
+	}
+}
@@ -1,9 +1,12 @@
 package user
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/duziem/ecommerce_proj/configs"
 	"github.com/duziem/ecommerce_proj/services/auth"
@@ -14,26 +17,44 @@ import (
 )
 
 type Handler struct {
-	store types.UserStore
+	store     types.UserStore
+	cartStore types.CartStore
+	mailStore types.MailStore
 }
 
-func NewHandler(store types.UserStore) *Handler {
-	return &Handler{store: store}
+func NewHandler(store types.UserStore, cartStore types.CartStore, mailStore types.MailStore) *Handler {
+	return &Handler{store: store, cartStore: cartStore, mailStore: mailStore}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// a guest cart sent along with the login is merged into the user's cart
 	router.HandleFunc("/login", h.handleLogin).Methods("POST")
 	router.HandleFunc("/register", h.handleRegister).Methods("POST")
+	// swap a refresh token for a new access token and refresh token
+	router.HandleFunc("/token/refresh", h.handleRefreshToken).Methods(http.MethodPost)
+	// revoke the session of the access token
+	router.HandleFunc("/logout", auth.WithJWTAuth(h.handleLogout, h.store)).Methods(http.MethodPost)
+	// revoke every session of the user
+	router.HandleFunc("/logout/all", auth.WithJWTAuth(h.handleLogoutAll, h.store)).Methods(http.MethodPost)
+
+	// email a password reset link, the response is the same whether or not the email has an account
+	router.HandleFunc("/password/forgot", h.handleForgotPassword).Methods(http.MethodPost)
+	// set a new password with the token from the email, this signs out every session
+	router.HandleFunc("/password/reset", h.handleResetPassword).Methods(http.MethodPost)
+	// confirm the email address with the token sent on registration
+	router.HandleFunc("/email/verify", h.handleVerifyEmail).Methods(http.MethodPost)
+	// send a new verification email
+	router.HandleFunc("/email/verify/resend", auth.WithJWTAuth(h.handleResendVerification, h.store)).Methods(http.MethodPost)
 
 	// admin routes
 	// get a user
-	router.HandleFunc("/admin/users/{userID}", auth.WithJWTAuth(h.handleGetUser, h.store)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/users/{userID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionUsersRead, h.handleGetUser, h.store), h.store)).Methods(http.MethodGet)
 
 	// helper routes
 	// get a user by email
-	router.HandleFunc("/users", auth.WithJWTAuth(h.handleGetUserByEmail, h.store)).Methods(http.MethodPost)
-	// update a user - upgrade a user to admin role
-	router.HandleFunc("/users/{userID}", auth.WithJWTAuth(h.handleUpdateUserRole, h.store)).Methods(http.MethodPatch)
+	router.HandleFunc("/users", auth.WithJWTAuth(auth.RequirePermission(types.PermissionUsersRead, h.handleGetUserByEmail, h.store), h.store)).Methods(http.MethodPost)
+	// update a user - give a user the admin role, see /admin/users/{userID}/roles for the other roles
+	router.HandleFunc("/users/{userID}", auth.WithJWTAuth(auth.RequirePermission(types.PermissionUsersManage, h.handleUpdateUserRole, h.store), h.store)).Methods(http.MethodPatch)
 }
 
 func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -60,14 +81,114 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	secret := []byte(configs.Envs.JWTSecret)
-	token, err := auth.CreateJWT(secret, u.ID)
+	refreshToken, refreshTokenHash, err := auth.GenerateOpaqueToken()
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, map[string]string{"token": token})
+	session, err := h.store.CreateSession(u.ID, refreshTokenHash, refreshTokenExpiry())
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	tokens, err := createTokens(session, refreshToken)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// a failed merge shouldn't prevent the login, the guest cart is kept
+	if cartToken := utils.GetCartTokenFromRequest(r); cartToken != "" {
+		if err := h.cartStore.MergeGuestCart(auth.HashOpaqueToken(cartToken), u.ID); err != nil {
+			log.Printf("failed to merge guest cart for user %d: %v", u.ID, err)
+		} else {
+			utils.ClearCartTokenCookie(w)
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, tokens)
+}
+
+func (h *Handler) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	var payload types.RefreshTokenPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	refreshToken, refreshTokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	session, err := h.store.RotateRefreshToken(auth.HashOpaqueToken(payload.RefreshToken), refreshTokenHash, refreshTokenExpiry())
+	if errors.Is(err, ErrRefreshTokenReused) {
+		log.Printf("refresh token reused, revoked its session: %v", err)
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	tokens, err := createTokens(session, refreshToken)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, tokens)
+}
+
+func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if err := h.store.RevokeSession(auth.GetSessionIDFromContext(r.Context())); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "logged out successfully"})
+}
+
+func (h *Handler) handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	if err := h.store.RevokeUserSessions(auth.GetUserIDFromContext(r.Context())); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"message": "logged out of all sessions successfully"})
+}
+
+// createTokens issues an access token for the session and pairs it with the
+// session's current refresh token.
+func createTokens(session *types.Session, refreshToken string) (map[string]interface{}, error) {
+	token, err := auth.CreateJWT(session.UserID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"token":        token,
+		"expiresIn":    configs.Envs.JWTExpirationInSeconds,
+		"refreshToken": refreshToken,
+	}, nil
+}
+
+func refreshTokenExpiry() time.Time {
+	return time.Now().Add(time.Second * time.Duration(configs.Envs.RefreshTokenTTLInSeconds))
 }
 
 func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
@@ -97,16 +218,36 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.store.CreateUser(types.User{
+	u := types.User{
 		FirstName: user.FirstName,
 		LastName:  user.LastName,
 		Email:     user.Email,
 		Password:  hashedPassword,
-	})
+	}
+
+	// the verification email is only sent if the user is created
+	tx, err := h.store.BeginTransaction()
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
+	defer tx.Rollback()
+
+	u.ID, err = h.store.CreateUserTx(tx, u)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.sendVerificationEmail(tx, u); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
 
 	utils.WriteJSON(w, http.StatusCreated, nil)
 }
@@ -176,7 +317,7 @@ func (h *Handler) handleUpdateUserRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.store.UpdateUserRole(*user, "admin")
+	err = h.store.UpdateUserRole(*user, types.RoleAdmin)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
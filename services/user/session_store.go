@@ -0,0 +1,145 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused means a rotated refresh token was presented again,
+	// so it has probably been stolen and its session is revoked
+	ErrRefreshTokenReused = errors.New("refresh token was already used, the session has been revoked")
+)
+
+// CreateSession starts a session with its first refresh token.
+func (s *Store) CreateSession(userID int, refreshTokenHash string, expiresAt time.Time) (*types.Session, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// stored in UTC like the other expiry times
+	expiresAt = expiresAt.UTC()
+	session := &types.Session{UserID: userID, ExpiresAt: expiresAt}
+	err = tx.QueryRow("INSERT INTO sessions (userId, expiresAt) VALUES ($1, $2) RETURNING id, createdAt", userID, expiresAt).
+		Scan(&session.ID, &session.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("INSERT INTO refresh_tokens (sessionId, tokenHash, expiresAt) VALUES ($1, $2, $3)", session.ID, refreshTokenHash, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// RotateRefreshToken spends a refresh token and replaces it with a new one,
+// extending its session. Presenting a token that was already spent revokes
+// the whole session.
+func (s *Store) RotateRefreshToken(tokenHash, newTokenHash string, expiresAt time.Time) (*types.Session, error) {
+	expiresAt = expiresAt.UTC()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// locking the token makes concurrent refreshes with it queue up, only
+	// the first one gets through
+	var (
+		tokenID          int
+		tokenExpiresAt   time.Time
+		usedAt           sql.NullTime
+		session          types.Session
+		sessionRevokedAt sql.NullTime
+	)
+	err = tx.QueryRow(`
+		SELECT t.id, t.expiresAt, t.usedAt, s.id, s.userId, s.revokedAt, s.createdAt
+		FROM refresh_tokens t JOIN sessions s ON s.id = t.sessionId
+		WHERE t.tokenHash = $1
+		FOR UPDATE`, tokenHash,
+	).Scan(&tokenID, &tokenExpiresAt, &usedAt, &session.ID, &session.UserID, &sessionRevokedAt, &session.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sessionRevokedAt.Valid {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if usedAt.Valid {
+		if _, err := tx.Exec("UPDATE sessions SET revokedAt = NOW() WHERE id = $1", session.ID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrRefreshTokenReused
+	}
+
+	if !tokenExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if _, err := tx.Exec("UPDATE refresh_tokens SET usedAt = NOW() WHERE id = $1", tokenID); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("INSERT INTO refresh_tokens (sessionId, tokenHash, expiresAt) VALUES ($1, $2, $3)", session.ID, newTokenHash, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("UPDATE sessions SET expiresAt = $1 WHERE id = $2", expiresAt, session.ID); err != nil {
+		return nil, err
+	}
+	session.ExpiresAt = expiresAt
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// GetActiveSession returns the session unless it was revoked or has expired.
+func (s *Store) GetActiveSession(id int) (*types.Session, error) {
+	session := new(types.Session)
+	err := s.db.QueryRow("SELECT id, userId, expiresAt, revokedAt, createdAt FROM sessions WHERE id = $1 AND revokedAt IS NULL AND expiresAt > $2", id, time.Now().UTC()).
+		Scan(&session.ID, &session.UserID, &session.ExpiresAt, &session.RevokedAt, &session.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("session not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (s *Store) RevokeSession(id int) error {
+	_, err := s.db.Exec("UPDATE sessions SET revokedAt = NOW() WHERE id = $1 AND revokedAt IS NULL", id)
+	return err
+}
+
+func (s *Store) RevokeUserSessions(userID int) error {
+	_, err := s.db.Exec("UPDATE sessions SET revokedAt = NOW() WHERE userId = $1 AND revokedAt IS NULL", userID)
+	return err
+}
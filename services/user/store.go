@@ -5,8 +5,24 @@ import (
 	"fmt"
 
 	"github.com/duziem/ecommerce_proj/types"
+	"github.com/lib/pq"
 )
 
+// userColumns lists the columns read by scanRowsIntoUser, in scan order.
+// The names of the user's roles are aggregated from user_roles.
+const userColumns = `id, firstName, lastName, email, password, createdAt,
+	ARRAY(SELECT r.name FROM user_roles ur JOIN roles r ON r.id = ur.roleId WHERE ur.userId = users.id ORDER BY r.name),
+	emailVerifiedAt`
+
+// createUserQuery inserts a user with the default role
+const createUserQuery = `
+	WITH u AS (
+		INSERT INTO users (firstName, lastName, email, password) VALUES ($1, $2, $3, $4) RETURNING id
+	), ur AS (
+		INSERT INTO user_roles (userId, roleId) SELECT u.id, r.id FROM u, roles r WHERE r.name = $5
+	)
+	SELECT id FROM u`
+
 type Store struct {
 	db *sql.DB
 }
@@ -15,8 +31,16 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
+func (s *Store) BeginTransaction() (*sql.Tx, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return tx, nil
+}
+
 func (s *Store) CreateUser(user types.User) error {
-	_, err := s.db.Exec("INSERT INTO users (firstName, lastName, email, password) VALUES ($1, $2, $3, $4)", user.FirstName, user.LastName, user.Email, user.Password)
+	_, err := s.db.Exec(createUserQuery, user.FirstName, user.LastName, user.Email, user.Password, types.RoleUser)
 	if err != nil {
 		return err
 	}
@@ -24,8 +48,18 @@ func (s *Store) CreateUser(user types.User) error {
 	return nil
 }
 
+func (s *Store) CreateUserTx(tx *sql.Tx, user types.User) (int, error) {
+	var id int
+	err := tx.QueryRow(createUserQuery, user.FirstName, user.LastName, user.Email, user.Password, types.RoleUser).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
 func (s *Store) GetUserByEmail(email string) (*types.User, error) {
-	rows, err := s.db.Query("SELECT * FROM users WHERE email = $1", email)
+	rows, err := s.db.Query("SELECT "+userColumns+" FROM users WHERE email = $1", email)
 	if err != nil {
 		return nil, err
 	}
@@ -46,7 +80,7 @@ func (s *Store) GetUserByEmail(email string) (*types.User, error) {
 }
 
 func (s *Store) GetUserByID(id int) (*types.User, error) {
-	rows, err := s.db.Query("SELECT * FROM users WHERE id = $1", id)
+	rows, err := s.db.Query("SELECT "+userColumns+" FROM users WHERE id = $1", id)
 	if err != nil {
 		return nil, err
 	}
@@ -66,17 +100,57 @@ func (s *Store) GetUserByID(id int) (*types.User, error) {
 	return u, nil
 }
 
+// UpdateUserRole gives the user the role, on top of the roles they have.
 func (s *Store) UpdateUserRole(user types.User, role string) error {
-	query := "UPDATE users SET role = $1 WHERE id = $2"
-
-	_, err := s.db.Exec(query, role, user.ID)
+	res, err := s.db.Exec(`
+		INSERT INTO user_roles (userId, roleId) SELECT $1, id FROM roles WHERE name = $2
+		ON CONFLICT DO NOTHING`, user.ID, role)
 	if err != nil {
 		return err
 	}
 
+	// nothing is inserted for an unknown role, nor for one the user already has
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists bool
+		if err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)", role).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("role %s not found", role)
+		}
+	}
+
 	return nil
 }
 
+// GetUserPermissions returns the names of the permissions the user has
+// through any of their roles.
+func (s *Store) GetUserPermissions(userID int) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT DISTINCT p.name
+		FROM user_roles ur
+		JOIN role_permissions rp ON rp.roleId = ur.roleId
+		JOIN permissions p ON p.id = rp.permissionId
+		WHERE ur.userId = $1
+		ORDER BY p.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, name)
+	}
+
+	return permissions, rows.Err()
+}
+
 func scanRowsIntoUser(rows *sql.Rows) (*types.User, error) {
 	user := new(types.User)
 
@@ -87,7 +161,8 @@ func scanRowsIntoUser(rows *sql.Rows) (*types.User, error) {
 		&user.Email,
 		&user.Password,
 		&user.CreatedAt,
-		&user.Role,
+		pq.Array(&user.Roles),
+		&user.EmailVerifiedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,57 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/duziem/ecommerce_proj/types"
+)
+
+var ErrInvalidUserToken = errors.New("invalid or expired token")
+
+// CreateUserToken stores a token mailed to the user, replacing the unused
+// tokens they were sent before for the same purpose.
+func (s *Store) CreateUserToken(tx *sql.Tx, userID int, purpose types.UserTokenPurpose, tokenHash string, expiresAt time.Time) error {
+	_, err := tx.Exec("UPDATE user_tokens SET usedAt = NOW() WHERE userId = $1 AND purpose = $2 AND usedAt IS NULL", userID, purpose)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO user_tokens (userId, purpose, tokenHash, expiresAt) VALUES ($1, $2, $3, $4)",
+		userID, purpose, tokenHash, expiresAt.UTC())
+	return err
+}
+
+// ConsumeUserToken uses up a token and returns the user it was sent to.
+func (s *Store) ConsumeUserToken(tx *sql.Tx, purpose types.UserTokenPurpose, tokenHash string) (int, error) {
+	var userID int
+	err := tx.QueryRow(`
+		UPDATE user_tokens SET usedAt = NOW()
+		WHERE tokenHash = $1 AND purpose = $2 AND usedAt IS NULL AND expiresAt > $3
+		RETURNING userId`, tokenHash, purpose, time.Now().UTC(),
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidUserToken
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// ResetPassword changes the user's password and signs them out everywhere.
+func (s *Store) ResetPassword(tx *sql.Tx, userID int, passwordHash string) error {
+	if _, err := tx.Exec("UPDATE users SET password = $1 WHERE id = $2", passwordHash, userID); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec("UPDATE sessions SET revokedAt = NOW() WHERE userId = $1 AND revokedAt IS NULL", userID)
+	return err
+}
+
+func (s *Store) MarkEmailVerified(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec("UPDATE users SET emailVerifiedAt = COALESCE(emailVerifiedAt, NOW()) WHERE id = $1", userID)
+	return err
+}
@@ -0,0 +1,252 @@
+package types
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/duziem/ecommerce_proj/configs"
+)
+
+// moneyScale is the number of decimal places kept for every currency,
+// matching the DECIMAL(10, 2) columns money is stored in.
+const moneyScale = 2
+
+const minorUnitsPerMajor = 100
+
+// Money is an exact amount expressed in minor units (e.g. cents) of a currency.
+//
+// It is stored as a DECIMAL and encoded in JSON as
+// {"amount": "12.50", "currency": "USD"}; the amount is always a string so
+// clients never have to round trip it through a float.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// NewMoney creates an amount of minor units in the given currency, or in the
+// store currency when currency is empty.
+func NewMoney(amount int64, currency string) Money {
+	if currency == "" {
+		currency = configs.Envs.Currency
+	}
+
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// ParseMoney parses a decimal string such as "12.5" or "-3.99" without going
+// through floating point. Amounts with more than two decimal places are rejected.
+func ParseMoney(str string, currency string) (Money, error) {
+	str = strings.TrimSpace(str)
+
+	negative := strings.HasPrefix(str, "-")
+	str = strings.TrimPrefix(strings.TrimPrefix(str, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(str, ".")
+	if whole == "" && fraction == "" {
+		return Money{}, fmt.Errorf("invalid amount %q", str)
+	}
+	if len(fraction) > moneyScale {
+		// trailing zeros such as in "12.5000" don't lose precision
+		if strings.TrimRight(fraction[moneyScale:], "0") != "" {
+			return Money{}, fmt.Errorf("amount %q has more than %d decimal places", str, moneyScale)
+		}
+		fraction = fraction[:moneyScale]
+	}
+	if !isDigits(whole) || !isDigits(fraction) {
+		return Money{}, fmt.Errorf("invalid amount %q", str)
+	}
+
+	fraction += strings.Repeat("0", moneyScale-len(fraction))
+	if whole == "" {
+		whole = "0"
+	}
+
+	// the sign is parsed with the digits so the most negative amount fits
+	digits := whole + fraction
+	if negative {
+		digits = "-" + digits
+	}
+
+	amount, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q", str)
+	}
+
+	return NewMoney(amount, currency), nil
+}
+
+func isDigits(str string) bool {
+	for _, r := range str {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String formats the amount as a decimal without the currency, e.g. "12.50".
+func (m Money) String() string {
+	// unsigned so negating the most negative amount doesn't overflow
+	amount := uint64(m.Amount)
+	sign := ""
+	if m.Amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, amount/minorUnitsPerMajor, amount%minorUnitsPerMajor)
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if err := m.checkCurrency(other); err != nil {
+		return Money{}, err
+	}
+
+	if (other.Amount > 0 && m.Amount > math.MaxInt64-other.Amount) ||
+		(other.Amount < 0 && m.Amount < math.MinInt64-other.Amount) {
+		return Money{}, fmt.Errorf("amount overflow")
+	}
+
+	return Money{Amount: m.Amount + other.Amount, Currency: m.currency(other)}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	return m.Add(Money{Amount: -other.Amount, Currency: other.Currency})
+}
+
+// Mul multiplies the amount by a quantity.
+func (m Money) Mul(quantity int) (Money, error) {
+	q := int64(quantity)
+	if q != 0 && (m.Amount*q)/q != m.Amount {
+		return Money{}, fmt.Errorf("amount overflow")
+	}
+	// MinInt64 * -1 wraps around to itself, which the division doesn't catch
+	if q == -1 && m.Amount == math.MinInt64 {
+		return Money{}, fmt.Errorf("amount overflow")
+	}
+
+	return Money{Amount: m.Amount * q, Currency: m.Currency}, nil
+}
+
+// Cmp returns -1, 0 or 1 when m is less than, equal to or greater than other.
+func (m Money) Cmp(other Money) (int, error) {
+	if err := m.checkCurrency(other); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case m.Amount < other.Amount:
+		return -1, nil
+	case m.Amount > other.Amount:
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// a zero value Money has no currency and is compatible with every currency
+func (m Money) checkCurrency(other Money) error {
+	if m.Currency != "" && other.Currency != "" && m.Currency != other.Currency {
+		return fmt.Errorf("currency mismatch: %s and %s", m.Currency, other.Currency)
+	}
+
+	return nil
+}
+
+func (m Money) currency(other Money) string {
+	if m.Currency != "" {
+		return m.Currency
+	}
+
+	return other.Currency
+}
+
+type moneyJSON struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{
+		Amount:   m.String(),
+		Currency: m.Currency,
+	})
+}
+
+// UnmarshalJSON accepts the object form as well as a bare amount, "12.50" or
+// 12.50, in the store currency. Numbers are parsed from their literal text so
+// they are never rounded.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	currency := ""
+
+	if len(data) > 0 && data[0] == '{' {
+		var v moneyJSON
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+
+		data = bytes.TrimSpace(v.Amount)
+		currency = v.Currency
+	}
+
+	str := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+	}
+
+	parsed, err := ParseMoney(str, currency)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// Scan reads a DECIMAL column in the store currency.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	case int64:
+		*m = NewMoney(v*minorUnitsPerMajor, "")
+		return nil
+	case nil:
+		return fmt.Errorf("cannot scan NULL into Money")
+	}
+
+	return fmt.Errorf("cannot scan %T into Money", src)
+}
+
+func (m *Money) scanString(str string) error {
+	parsed, err := ParseMoney(str, "")
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// Value writes the amount as a decimal string.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
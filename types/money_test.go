@@ -0,0 +1,198 @@
+package types
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	_ "github.com/lib/pq"
+)
+
+func TestParseMoneyStringRoundTrip(t *testing.T) {
+	roundTrips := func(amount int64) bool {
+		m := Money{Amount: amount, Currency: "USD"}
+
+		parsed, err := ParseMoney(m.String(), "USD")
+		return err == nil && parsed == m
+	}
+
+	for _, amount := range []int64{0, 1, -1, 99, -99, 100, 1050, math.MaxInt64, math.MinInt64} {
+		if !roundTrips(amount) {
+			t.Errorf("%d did not survive String and ParseMoney", amount)
+		}
+	}
+
+	if err := quick.Check(roundTrips, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMoneyAddOverflow(t *testing.T) {
+	add := func(a, b int64) bool {
+		sum, err := Money{Amount: a}.Add(Money{Amount: b})
+
+		want := new(big.Int).Add(big.NewInt(a), big.NewInt(b))
+		if !want.IsInt64() {
+			return err != nil
+		}
+
+		return err == nil && sum.Amount == want.Int64()
+	}
+
+	for _, c := range [][2]int64{{math.MaxInt64, 1}, {math.MinInt64, -1}, {math.MaxInt64, math.MinInt64}, {math.MinInt64, 0}} {
+		if !add(c[0], c[1]) {
+			t.Errorf("%d + %d was not added or rejected correctly", c[0], c[1])
+		}
+	}
+
+	if err := quick.Check(add, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMoneyMulOverflow(t *testing.T) {
+	mul := func(a int64, quantity int) bool {
+		product, err := Money{Amount: a}.Mul(quantity)
+
+		want := new(big.Int).Mul(big.NewInt(a), big.NewInt(int64(quantity)))
+		if !want.IsInt64() {
+			return err != nil
+		}
+
+		return err == nil && product.Amount == want.Int64()
+	}
+
+	cases := []struct {
+		amount   int64
+		quantity int
+	}{
+		{math.MaxInt64, 2},
+		{math.MinInt64, -1},
+		{-1, math.MinInt64},
+		{math.MinInt64, 1},
+		{math.MaxInt64 / 3, 3},
+		{math.MaxInt64/3 + 1, 3},
+	}
+	for _, c := range cases {
+		if !mul(c.amount, c.quantity) {
+			t.Errorf("%d * %d was not multiplied or rejected correctly", c.amount, c.quantity)
+		}
+	}
+
+	if err := quick.Check(mul, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+type cartLine struct {
+	quantity int
+	price    Money
+}
+
+// randomCart has prices that fit the DECIMAL(10, 2) price columns.
+func randomCart(r *rand.Rand) []cartLine {
+	lines := make([]cartLine, 1+r.Intn(20))
+	for i := range lines {
+		lines[i] = cartLine{
+			quantity: 1 + r.Intn(1000),
+			price:    Money{Amount: r.Int63n(1e10), Currency: "USD"},
+		}
+	}
+
+	return lines
+}
+
+// cartTotal adds up the lines the way checkout does.
+func cartTotal(lines []cartLine) (Money, error) {
+	total := Money{Currency: "USD"}
+	for _, line := range lines {
+		lineTotal, err := line.price.Mul(line.quantity)
+		if err != nil {
+			return Money{}, err
+		}
+
+		if total, err = total.Add(lineTotal); err != nil {
+			return Money{}, err
+		}
+	}
+
+	return total, nil
+}
+
+// decimalSum computes SUM(quantity * price) on the decimal text of the
+// prices, as Postgres does on the DECIMAL columns.
+func decimalSum(lines []cartLine) string {
+	sum := new(big.Rat)
+	for _, line := range lines {
+		price, _ := new(big.Rat).SetString(line.price.String())
+		sum.Add(sum, price.Mul(price, big.NewRat(int64(line.quantity), 1)))
+	}
+
+	return sum.FloatString(moneyScale)
+}
+
+func TestCartTotalMatchesDecimalSum(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 1000; i++ {
+		lines := randomCart(r)
+
+		total, err := cartTotal(lines)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := decimalSum(lines); total.String() != want {
+			t.Fatalf("cart total %s, SUM(quantity * price) is %s", total, want)
+		}
+	}
+}
+
+// TestCartTotalMatchesSQLSum runs the sum in Postgres when TEST_DATABASE_URL
+// points at a database.
+func TestCartTotalMatchesSQLSum(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 100; i++ {
+		lines := randomCart(r)
+
+		var args []interface{}
+		var placeholders []string
+		for j, line := range lines {
+			placeholders = append(placeholders, fmt.Sprintf("($%d::integer, $%d::DECIMAL(10, 2))", j*2+1, j*2+2))
+			args = append(args, line.quantity, line.price)
+		}
+
+		var sum Money
+		query := "SELECT SUM(quantity * price) FROM (VALUES " + strings.Join(placeholders, ", ") + ") AS items(quantity, price)"
+		if err := db.QueryRow(query, args...).Scan(&sum); err != nil {
+			t.Fatal(err)
+		}
+
+		total, err := cartTotal(lines)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if total.Amount != sum.Amount {
+			t.Fatalf("cart total %s, SUM(quantity * price) is %s", total, sum)
+		}
+	}
+}
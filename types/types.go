@@ -2,102 +2,869 @@ package types
 
 import (
 	"database/sql"
+	"slices"
 	"time"
 )
 
 type User struct {
-	ID        int       `json:"id"`
-	FirstName string    `json:"firstName"`
-	LastName  string    `json:"lastName"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"`
-	Role      string    `json:"role"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID              int        `json:"id"`
+	FirstName       string     `json:"firstName"`
+	LastName        string     `json:"lastName"`
+	Email           string     `json:"email"`
+	Password        string     `json:"-"`
+	Roles           []string   `json:"roles"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
 }
 
-type Product struct {
+// Permissions are granted to users through their roles.
+const (
+	PermissionProductsRead    = "products:read"
+	PermissionProductsWrite   = "products:write"
+	PermissionOrdersRead      = "orders:read"
+	PermissionOrdersWrite     = "orders:write"
+	PermissionOrdersRefund    = "orders:refund"
+	PermissionPromotionsRead  = "promotions:read"
+	PermissionPromotionsWrite = "promotions:write"
+	PermissionUsersRead       = "users:read"
+	PermissionUsersManage     = "users:manage"
+)
+
+// RoleAdmin has every permission, RoleUser is given to every new user.
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+type Role struct {
 	ID          int       `json:"id"`
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
-	Image       string    `json:"image"`
-	Price       float64   `json:"price"`
-	Quantity    int       `json:"quantity"`
+	Permissions []string  `json:"permissions"`
 	CreatedAt   time.Time `json:"createdAt"`
 }
 
+type Permission struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// UserTokenPurpose is what a token mailed to a user can be used for.
+type UserTokenPurpose string
+
+const (
+	UserTokenPasswordReset     UserTokenPurpose = "password_reset"
+	UserTokenEmailVerification UserTokenPurpose = "email_verification"
+)
+
+type EmailStatus string
+
+const (
+	EmailStatusPending EmailStatus = "pending"
+	EmailStatusSent    EmailStatus = "sent"
+	EmailStatusFailed  EmailStatus = "failed"
+)
+
+// Email is a message in the outbox, it is retried at NextAttemptAt until it
+// is sent or runs out of attempts.
+type Email struct {
+	ID            int         `json:"id"`
+	To            string      `json:"to"`
+	Subject       string      `json:"subject"`
+	Body          string      `json:"body"`
+	Status        EmailStatus `json:"status"`
+	Attempts      int         `json:"attempts"`
+	LastError     string      `json:"lastError,omitempty"`
+	NextAttemptAt time.Time   `json:"nextAttemptAt"`
+	SentAt        *time.Time  `json:"sentAt,omitempty"`
+	CreatedAt     time.Time   `json:"createdAt"`
+}
+
+// Session is a login. Its refresh token is rotated on every use, each access
+// token names the session it was issued for and stops working once the
+// session is revoked or expires.
+type Session struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"userID"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// Product.Quantity is the total stock across all of the product's variants.
+// Product is archived, hidden from the catalog and checkout, when DeletedAt is set.
+type Product struct {
+	ID          int              `json:"id"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Image       string           `json:"image"`
+	Price       Money            `json:"price"`
+	Quantity    int              `json:"quantity"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	DeletedAt   *time.Time       `json:"deletedAt,omitempty"`
+	Images      []ProductImage   `json:"images,omitempty"`
+	Options     []ProductOption  `json:"options,omitempty"`
+	Variants    []ProductVariant `json:"variants,omitempty"`
+}
+
+// FindVariant returns the variant with the given ID. An ID of 0 selects the
+// product's only variant, and fails when the product has several.
+func (p Product) FindVariant(id int) (ProductVariant, bool) {
+	if id == 0 {
+		if len(p.Variants) == 1 {
+			return p.Variants[0], true
+		}
+		return ProductVariant{}, false
+	}
+
+	for _, v := range p.Variants {
+		if v.ID == id {
+			return v, true
+		}
+	}
+
+	return ProductVariant{}, false
+}
+
+type ProductOption struct {
+	ID        int      `json:"id"`
+	ProductID int      `json:"productID"`
+	Name      string   `json:"name"`
+	Values    []string `json:"values"`
+}
+
+// ProductImage is an uploaded image of a product, the one at position 0
+// being its main image. Key and ThumbnailKeys name the stored files, URL and
+// Thumbnails are where they are served from.
+type ProductImage struct {
+	ID            int               `json:"id"`
+	ProductID     int               `json:"productID"`
+	Position      int               `json:"position"`
+	Key           string            `json:"-"`
+	ThumbnailKeys map[string]string `json:"-"`
+	URL           string            `json:"url"`
+	Thumbnails    map[string]string `json:"thumbnails"`
+	ContentType   string            `json:"contentType"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Size          int64             `json:"size"`
+	CreatedAt     time.Time         `json:"createdAt"`
+}
+
+// ProductVariant is a sellable SKU of a product. Price is the effective unit
+// price: PriceOverride when set, the product price otherwise. Quantity is
+// the stock on hand, of which Reserved is held for unpaid orders and
+// Available can still be sold.
+type ProductVariant struct {
+	ID            int               `json:"id"`
+	ProductID     int               `json:"productID"`
+	SKU           string            `json:"sku"`
+	Price         Money             `json:"price"`
+	PriceOverride *Money            `json:"priceOverride"`
+	Quantity      int               `json:"quantity"`
+	Reserved      int               `json:"reserved"`
+	Available     int               `json:"available"`
+	Options       map[string]string `json:"options"`
+	CreatedAt     time.Time         `json:"createdAt"`
+}
+
+// ProductQueryOptions describes a page of the product catalog. Cursor and
+// Offset are mutually exclusive; when Cursor is set the page starts right
+// after the row it encodes.
+type ProductQueryOptions struct {
+	Limit        int
+	Offset       int
+	Cursor       string
+	MinPrice     *Money
+	MaxPrice     *Money
+	InStock      bool
+	CreatedAfter *time.Time
+	CategoryIDs  []int
+	SortBy       string
+	SortOrder    string
+}
+
+// OrderQueryOptions filters and sorts the orders listed to admins. Zero
+// values and nil pointers don't filter.
+type OrderQueryOptions struct {
+	Limit       int
+	Offset      int
+	Statuses    []OrderStatus
+	UserID      int
+	ProductID   int
+	Search      string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	MinTotal    *Money
+	MaxTotal    *Money
+	SortBy      string
+	SortOrder   string
+}
+
+type PaginationMetadata struct {
+	TotalCount int    `json:"totalCount"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+type ProductPage struct {
+	Data     []*Product         `json:"data"`
+	Metadata PaginationMetadata `json:"metadata"`
+}
+
+type ProductSearchResult struct {
+	Product
+	Rank                 float64 `json:"rank"`
+	NameHighlight        string  `json:"nameHighlight"`
+	DescriptionHighlight string  `json:"descriptionHighlight"`
+}
+
+type ProductSearchPage struct {
+	Data     []*ProductSearchResult `json:"data"`
+	Metadata PaginationMetadata     `json:"metadata"`
+}
+
+type Category struct {
+	ID          int         `json:"id"`
+	ParentID    *int        `json:"parentID"`
+	Name        string      `json:"name"`
+	Slug        string      `json:"slug"`
+	Description string      `json:"description"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	Children    []*Category `json:"children,omitempty"`
+}
+
+const (
+	PromotionPercentage   = "percentage"
+	PromotionFixedAmount  = "fixed_amount"
+	PromotionFreeShipping = "free_shipping"
+	PromotionBuyXGetY     = "buy_x_get_y"
+)
+
+// Promotion is a discount code that only discounts the products in
+// ProductIDs, or every product when it is empty. Free shipping applies when
+// the cart has one of those products.
+// Nil limits and dates mean no limit.
+type Promotion struct {
+	ID            int        `json:"id"`
+	Code          string     `json:"code"`
+	Description   string     `json:"description"`
+	Type          string     `json:"type"`
+	Percentage    int        `json:"percentage,omitempty"`
+	Amount        *Money     `json:"amount,omitempty"`
+	BuyQuantity   int        `json:"buyQuantity,omitempty"`
+	GetQuantity   int        `json:"getQuantity,omitempty"`
+	ProductIDs    []int      `json:"productIDs"`
+	MinOrderValue *Money     `json:"minOrderValue,omitempty"`
+	UsageLimit    *int       `json:"usageLimit,omitempty"`
+	PerUserLimit  *int       `json:"perUserLimit,omitempty"`
+	TimesUsed     int        `json:"timesUsed"`
+	StartsAt      *time.Time `json:"startsAt,omitempty"`
+	EndsAt        *time.Time `json:"endsAt,omitempty"`
+	Active        bool       `json:"active"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+// PromotionDiscount is the result of applying a promotion to a checkout.
+// ItemDiscounts lines up with the checked out items.
+type PromotionDiscount struct {
+	Discount         Money
+	ShippingDiscount Money
+	ItemDiscounts    []Money
+}
+
+// CartCheckoutItem.VariantID may be omitted for products with a single variant.
 type CartCheckoutItem struct {
 	ProductID int `json:"productID"`
+	VariantID int `json:"variantID,omitempty"`
 	Quantity  int `json:"quantity"`
 }
 
-type Order struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"userID"`
-	Total     float64   `json:"total"`
-	Status    string    `json:"status"`
-	Address   string    `json:"address"`
+// Cart.UserID is nil for a guest cart, which is found by the hash of its token.
+type Cart struct {
+	ID        int        `json:"id"`
+	UserID    *int       `json:"userID"`
+	Items     []CartItem `json:"items"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// CartItem.Price is the unit price when the item was added.
+type CartItem struct {
+	ProductID int       `json:"productID"`
+	VariantID int       `json:"variantID"`
+	Quantity  int       `json:"quantity"`
+	Price     Money     `json:"price"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+// CartView is a cart priced against the current catalog.
+type CartView struct {
+	ID        int        `json:"id"`
+	Items     []CartLine `json:"items"`
+	Subtotal  Money      `json:"subtotal"`
+	Warnings  []string   `json:"warnings"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+type CartLine struct {
+	ProductID int               `json:"productID"`
+	VariantID int               `json:"variantID"`
+	SKU       string            `json:"sku"`
+	Name      string            `json:"name"`
+	Image     string            `json:"image"`
+	Options   map[string]string `json:"options,omitempty"`
+	Quantity  int               `json:"quantity"`
+	UnitPrice Money             `json:"unitPrice"`
+	LineTotal Money             `json:"lineTotal"`
+	Available int               `json:"available"`
+	Warning   string            `json:"warning,omitempty"`
+}
+
+type OrderStatus string
+
+const (
+	OrderStatusPending    OrderStatus = "pending"
+	OrderStatusPaid       OrderStatus = "paid"
+	OrderStatusProcessing OrderStatus = "processing"
+	OrderStatusShipped    OrderStatus = "shipped"
+	OrderStatusDelivered  OrderStatus = "delivered"
+	OrderStatusCancelled  OrderStatus = "cancelled"
+	OrderStatusRefunded   OrderStatus = "refunded"
+
+	OrderStatusPartiallyRefunded OrderStatus = "partially_refunded"
+)
+
+// orderStatusTransitions lists the statuses an order can move to from each
+// status. Cancelled and refunded orders are final, a partially refunded order
+// stays so until everything has been refunded.
+var orderStatusTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPending:           {OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusPaid:              {OrderStatusProcessing, OrderStatusCancelled, OrderStatusPartiallyRefunded, OrderStatusRefunded},
+	OrderStatusProcessing:        {OrderStatusShipped, OrderStatusCancelled, OrderStatusPartiallyRefunded, OrderStatusRefunded},
+	OrderStatusShipped:           {OrderStatusDelivered, OrderStatusPartiallyRefunded, OrderStatusRefunded},
+	OrderStatusDelivered:         {OrderStatusPartiallyRefunded, OrderStatusRefunded},
+	OrderStatusPartiallyRefunded: {OrderStatusPartiallyRefunded, OrderStatusRefunded},
+	OrderStatusCancelled:         {},
+	OrderStatusRefunded:          {},
+}
+
+func (s OrderStatus) IsValid() bool {
+	_, ok := orderStatusTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether an order can move from s to next.
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	return slices.Contains(orderStatusTransitions[s], next)
+}
+
+// Order.Total is what the customer pays: the items, less Discount, plus
+// ShippingTotal.
+type Order struct {
+	ID            int         `json:"id"`
+	UserID        int         `json:"userID"`
+	Total         Money       `json:"total"`
+	Discount      Money       `json:"discount"`
+	ShippingTotal Money       `json:"shippingTotal"`
+	PromotionID   *int        `json:"promotionID,omitempty"`
+	Status        OrderStatus `json:"status"`
+	Address       string      `json:"address"`
+	CreatedAt     time.Time   `json:"createdAt"`
+}
+
+// OrderItem holds a snapshot of the product and variant as they were bought.
+// Discount is the part of the order discount taken off the item.
 type OrderItem struct {
+	ID           int               `json:"id"`
+	OrderID      int               `json:"orderID"`
+	ProductID    int               `json:"productID"`
+	VariantID    *int              `json:"variantID"`
+	ProductName  string            `json:"productName"`
+	ProductImage string            `json:"productImage"`
+	SKU          string            `json:"sku"`
+	Options      map[string]string `json:"options,omitempty"`
+	Quantity     int               `json:"quantity"`
+	Price        Money             `json:"price"`
+	Discount     Money             `json:"discount"`
+	CreatedAt    time.Time         `json:"createdAt"`
+}
+
+type OrderDetail struct {
+	Order
+	Items    []OrderItem `json:"items"`
+	Customer *User       `json:"customer,omitempty"`
+}
+
+type OrderPage struct {
+	Data     []*Order           `json:"data"`
+	Metadata PaginationMetadata `json:"metadata"`
+}
+
+const (
+	InventoryReasonInitial      = "initial"
+	InventoryReasonSale         = "sale"
+	InventoryReasonCancellation = "cancellation"
+	InventoryReasonManualAdjust = "manual_adjust"
+	InventoryReasonReturn       = "return"
+	InventoryReasonImport       = "import"
+)
+
+// InventoryMovement is a change to a variant's stock. Quantity is positive
+// when stock is added and negative when it is taken.
+type InventoryMovement struct {
 	ID        int       `json:"id"`
-	OrderID   int       `json:"orderID"`
 	ProductID int       `json:"productID"`
+	VariantID *int      `json:"variantID"`
+	OrderID   *int      `json:"orderID,omitempty"`
 	Quantity  int       `json:"quantity"`
-	Price     float64   `json:"price"`
+	Reason    string    `json:"reason"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+// InventoryMovementPage is a page of a product's stock history. Quantity is
+// the product's current stock.
+type InventoryMovementPage struct {
+	ProductID int                  `json:"productID"`
+	Quantity  int                  `json:"quantity"`
+	Data      []*InventoryMovement `json:"data"`
+	Metadata  PaginationMetadata   `json:"metadata"`
+}
+
+// InventoryDiscrepancy is a variant whose stock doesn't match its
+// LedgerQuantity, the sum of its inventory movements.
+type InventoryDiscrepancy struct {
+	ProductID      int    `json:"productID"`
+	VariantID      int    `json:"variantID"`
+	SKU            string `json:"sku"`
+	Quantity       int    `json:"quantity"`
+	LedgerQuantity int    `json:"ledgerQuantity"`
+}
+
+type ReservationStatus string
+
+const (
+	ReservationStatusActive    ReservationStatus = "active"
+	ReservationStatusCommitted ReservationStatus = "committed"
+	ReservationStatusReleased  ReservationStatus = "released"
+)
+
+// InventoryReservation holds stock for an unpaid order until it expires.
+// Committing it takes the quantity off the variant's stock, releasing it
+// makes the quantity available again.
+type InventoryReservation struct {
+	ID        int               `json:"id"`
+	OrderID   int               `json:"orderID"`
+	ProductID int               `json:"productID"`
+	VariantID int               `json:"variantID"`
+	Quantity  int               `json:"quantity"`
+	Status    ReservationStatus `json:"status"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+type ReturnStatus string
+
+const (
+	ReturnStatusRequested ReturnStatus = "requested"
+	ReturnStatusApproved  ReturnStatus = "approved"
+	ReturnStatusRejected  ReturnStatus = "rejected"
+	ReturnStatusReceived  ReturnStatus = "received"
+)
+
+// Return is a customer's request to send back some of an order's items.
+// RefundAmount is what approving it refunds.
+type Return struct {
+	ID           int          `json:"id"`
+	OrderID      int          `json:"orderID"`
+	UserID       int          `json:"userID"`
+	Status       ReturnStatus `json:"status"`
+	Reason       string       `json:"reason"`
+	AdminNote    string       `json:"adminNote"`
+	RefundAmount Money        `json:"refundAmount"`
+	Restocked    bool         `json:"restocked"`
+	Items        []ReturnItem `json:"items"`
+	CreatedAt    time.Time    `json:"createdAt"`
+	UpdatedAt    time.Time    `json:"updatedAt"`
+}
+
+type ReturnItem struct {
+	ID           int   `json:"id"`
+	ReturnID     int   `json:"returnID"`
+	OrderItemID  int   `json:"orderItemID"`
+	ProductID    int   `json:"productID"`
+	VariantID    *int  `json:"variantID"`
+	Quantity     int   `json:"quantity"`
+	RefundAmount Money `json:"refundAmount"`
+}
+
+type RefundStatus string
+
+const (
+	RefundStatusPending   RefundStatus = "pending"
+	RefundStatusCompleted RefundStatus = "completed"
+)
+
+// Refund is recorded as pending before the money is given back through the
+// payment provider, and completed once it has been.
+type Refund struct {
+	ID          int          `json:"id"`
+	OrderID     int          `json:"orderID"`
+	ReturnID    *int         `json:"returnID"`
+	Amount      Money        `json:"amount"`
+	Status      RefundStatus `json:"status"`
+	LastError   string       `json:"lastError,omitempty"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	CompletedAt *time.Time   `json:"completedAt"`
+}
+
+type PaymentStatus string
+
+const (
+	PaymentStatusCreated           PaymentStatus = "created"
+	PaymentStatusAuthorized        PaymentStatus = "authorized"
+	PaymentStatusCaptured          PaymentStatus = "captured"
+	PaymentStatusVoided            PaymentStatus = "voided"
+	PaymentStatusFailed            PaymentStatus = "failed"
+	PaymentStatusPartiallyRefunded PaymentStatus = "partially_refunded"
+	PaymentStatusRefunded          PaymentStatus = "refunded"
+)
+
+// PaymentIntent tracks the payment of an order with a payment provider.
+// ProviderRef is the provider's ID for the payment, set once authorized.
+type PaymentIntent struct {
+	ID             int           `json:"id"`
+	OrderID        int           `json:"orderID"`
+	Provider       string        `json:"provider"`
+	ProviderRef    string        `json:"providerRef"`
+	Status         PaymentStatus `json:"status"`
+	Amount         Money         `json:"amount"`
+	RefundedAmount Money         `json:"refundedAmount"`
+	LastError      string        `json:"lastError,omitempty"`
+	CreatedAt      time.Time     `json:"createdAt"`
+	UpdatedAt      time.Time     `json:"updatedAt"`
+}
+
+// IdempotencyKey stores the response to the first request sent with a key so
+// retries can be answered with it. StatusCode is nil while that request is in flight.
+type IdempotencyKey struct {
+	UserID       int
+	Key          string
+	RequestHash  string
+	StatusCode   *int
+	ResponseBody []byte
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
 type UserStore interface {
 	GetUserByEmail(email string) (*User, error)
 	GetUserByID(id int) (*User, error)
 	CreateUser(User) error
 	UpdateUserRole(User, string) error
+	GetUserPermissions(userID int) ([]string, error)
+	CreateSession(userID int, refreshTokenHash string, expiresAt time.Time) (*Session, error)
+	RotateRefreshToken(tokenHash, newTokenHash string, expiresAt time.Time) (*Session, error)
+	GetActiveSession(id int) (*Session, error)
+	RevokeSession(id int) error
+	RevokeUserSessions(userID int) error
+	BeginTransaction() (*sql.Tx, error)
+	CreateUserTx(tx *sql.Tx, user User) (int, error)
+	CreateUserToken(tx *sql.Tx, userID int, purpose UserTokenPurpose, tokenHash string, expiresAt time.Time) error
+	ConsumeUserToken(tx *sql.Tx, purpose UserTokenPurpose, tokenHash string) (int, error)
+	ResetPassword(tx *sql.Tx, userID int, passwordHash string) error
+	MarkEmailVerified(tx *sql.Tx, userID int) error
 }
 
 type ProductStore interface {
 	GetProductByID(id int) (*Product, error)
 	GetProductsByID(ids []int) ([]Product, error)
-	GetProducts() ([]*Product, error)
+	GetProducts(ProductQueryOptions) (*ProductPage, error)
+	SearchProducts(query string, limit, offset int) (*ProductSearchPage, error)
 	CreateProduct(CreateProductPayload) error
-	UpdateProduct(Product) error
+	UpdateProduct(product Product, quantity *int) error
 	DeleteProduct(id int) error
 	DeleteProducts(ids []int) error
+	RestoreProduct(id int) error
+	ImportProduct(tx *sql.Tx, product CreateProductPayload) (created bool, err error)
+	ExportProducts(fn func(CreateProductPayload) error) error
+	GetProductImages(productID int) ([]ProductImage, error)
+	CreateProductImage(image ProductImage) (*ProductImage, error)
+	DeleteProductImage(image ProductImage) error
+	ReorderProductImages(productID int, imageIDs []int) error
+	SetProductImage(productID int, image string) error
+	IsImageInOrders(url string) (bool, error)
+	PurgeProducts(deletedBefore time.Time) (int64, error)
 	BeginTransaction() (*sql.Tx, error)
 	GetProductsByIDWithLock(*sql.Tx, []int) ([]Product, error)
 	UpdateProductQuantities(*sql.Tx, []CartCheckoutItem) error
+	RestockProducts(*sql.Tx, []CartCheckoutItem) error
+	RecordInventoryMovements(*sql.Tx, []InventoryMovement) error
+	GetInventoryMovements(productID, limit, offset int) (*InventoryMovementPage, error)
+	GetInventoryDiscrepancies() ([]InventoryDiscrepancy, error)
+	ReserveStock(tx *sql.Tx, orderID int, items []CartCheckoutItem, expiresAt time.Time) error
+	GetOrderReservationsWithLock(tx *sql.Tx, orderID int) ([]InventoryReservation, error)
+	UpdateReservationsStatus(tx *sql.Tx, orderID int, status ReservationStatus) error
+	GetExpiredReservationOrderIDs(now time.Time, limit int) ([]int, error)
+	GetProductOptions(productID int) ([]ProductOption, error)
+	SetProductOptions(productID int, options []ProductOption) error
+	GetVariants(productID int) ([]ProductVariant, error)
+	GetVariantByID(id int) (*ProductVariant, error)
+	CreateVariant(ProductVariant) (int, error)
+	UpdateVariant(ProductVariant) error
+	DeleteVariant(ProductVariant) error
+}
+
+type CartStore interface {
+	GetCartByID(id int) (*Cart, error)
+	GetOrCreateUserCart(userID int) (*Cart, error)
+	GetGuestCart(tokenHash string) (*Cart, error)
+	CreateGuestCart(tokenHash string) (*Cart, error)
+	MergeGuestCart(tokenHash string, userID int) error
+	SetCartItem(cartID int, item CartItem) error
+	ReplaceCartItems(cartID int, items []CartItem) error
+	RemoveCartItems(cartID, productID, variantID int) error
+	ClearCart(cartID int) error
+	ClearCartTx(tx *sql.Tx, cartID int) error
 }
 
 type OrderStore interface {
 	CreateOrder(*sql.Tx, Order) (int, error)
-	CreateOrderItems(*sql.Tx, int, []CartCheckoutItem, map[int]Product) error
+	CreateOrderItems(*sql.Tx, int, []CartCheckoutItem, map[int]Product, []Money) error
 	GetOrders(id int) ([]*Order, error)
+	GetAllOrders(OrderQueryOptions) (*OrderPage, error)
 	GetOrderByID(id int) (*Order, error)
-	UpdateOrderStatus(*Order, string) error
+	GetOrderByIDWithLock(tx *sql.Tx, id int) (*Order, error)
+	GetOrderItems(orderID int) ([]OrderItem, error)
+	GetOrderItemsTx(tx *sql.Tx, orderID int) ([]OrderItem, error)
+	UpdateOrderStatus(*Order, OrderStatus) error
+	UpdateOrderStatusTx(*sql.Tx, *Order, OrderStatus) error
+}
+
+type CategoryStore interface {
+	GetCategories() ([]*Category, error)
+	GetCategoryByID(id int) (*Category, error)
+	GetCategoryBySlug(slug string) (*Category, error)
+	GetDescendantIDs(id int) ([]int, error)
+	CreateCategory(CreateCategoryPayload) (int, error)
+	UpdateCategory(Category) error
+	DeleteCategory(id int) error
+	AssignProducts(categoryID int, productIDs []int) error
+	RemoveProducts(categoryID int, productIDs []int) error
+}
+
+type PromotionStore interface {
+	GetPromotions() ([]*Promotion, error)
+	GetPromotionByID(id int) (*Promotion, error)
+	GetPromotionByCode(code string) (*Promotion, error)
+	CreatePromotion(Promotion) (int, error)
+	UpdatePromotion(Promotion) error
+	DeletePromotion(id int) error
+	GetPromotionByCodeWithLock(tx *sql.Tx, code string) (*Promotion, error)
+	CountUserRedemptions(tx *sql.Tx, promotionID, userID int) (int, error)
+	RedeemPromotion(tx *sql.Tx, promotionID, userID, orderID int, discount Money) error
+}
+
+type ReturnStore interface {
+	GetReturns(status ReturnStatus) ([]*Return, error)
+	GetReturnByID(id int) (*Return, error)
+	GetReturnsByOrderID(orderID int) ([]*Return, error)
+	GetReturnByIDWithLock(tx *sql.Tx, id int) (*Return, error)
+	GetReturnedQuantities(tx *sql.Tx, orderID int, statuses []ReturnStatus) (map[int]int, error)
+	CreateReturn(tx *sql.Tx, r Return) (int, error)
+	UpdateReturn(tx *sql.Tx, r Return) error
+	CreateRefund(tx *sql.Tx, refund Refund) (int, error)
+	GetRefundByReturnID(returnID int) (*Refund, error)
+	GetRefundByReturnIDWithLock(tx *sql.Tx, returnID int) (*Refund, error)
+	CompleteRefund(tx *sql.Tx, id int) error
+	SetRefundError(id int, lastError string) error
+}
+
+type PaymentStore interface {
+	CreatePaymentIntent(PaymentIntent) (int, error)
+	GetPaymentIntentByOrderID(orderID int) (*PaymentIntent, error)
+	GetPaymentIntentByOrderIDWithLock(tx *sql.Tx, orderID int) (*PaymentIntent, error)
+	GetPaymentIntentByProviderRef(provider, providerRef string) (*PaymentIntent, error)
+	UpdatePaymentIntent(PaymentIntent) error
+	UpdatePaymentIntentTx(tx *sql.Tx, intent PaymentIntent) error
+}
+
+type RBACStore interface {
+	GetRoles() ([]Role, error)
+	GetPermissions() ([]Permission, error)
+	GetUserRoles(userID int) ([]Role, error)
+	AssignUserRole(userID int, role string) error
+	RemoveUserRole(userID int, role string) error
 }
 
+type MailStore interface {
+	EnqueueEmail(tx *sql.Tx, email Email) error
+	ClaimPendingEmails(now time.Time, lease time.Duration, limit int) ([]Email, error)
+	MarkEmailSent(id int) error
+	MarkEmailFailed(id int, lastError string, nextAttemptAt *time.Time) error
+}
+
+type IdempotencyStore interface {
+	ClaimIdempotencyKey(IdempotencyKey) (*IdempotencyKey, bool, error)
+	SaveIdempotencyResponse(userID int, key string, statusCode int, body []byte) error
+	DeleteIdempotencyKey(userID int, key string) error
+}
+
+// CreateProductPayload.SKU names the product's initial variant, one is
+// generated when it is left empty.
 type CreateProductPayload struct {
-	Name        string  `json:"name" validate:"required"`
-	Description string  `json:"description"`
-	Image       string  `json:"image"`
-	Price       float64 `json:"price" validate:"required"`
-	Quantity    int     `json:"quantity" validate:"required"`
+	Name        string `json:"name" validate:"required"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	Price       Money  `json:"price" validate:"required,gt=0"`
+	Quantity    int    `json:"quantity" validate:"required"`
+	SKU         string `json:"sku,omitempty"`
+}
+
+// ImportReport describes the outcome of a product import. Rows are numbered
+// from 1, not counting a CSV header. Nothing is written on a dry run, nor by
+// an atomic import with failed rows. CommittedRows counts the rows read up to
+// the last commit, and Error says why an import stopped after some of its
+// rows were committed.
+type ImportReport struct {
+	DryRun        bool             `json:"dryRun"`
+	Atomic        bool             `json:"atomic"`
+	Rows          int              `json:"rows"`
+	Created       int              `json:"created"`
+	Updated       int              `json:"updated"`
+	Failed        int              `json:"failed"`
+	CommittedRows int              `json:"committedRows"`
+	Error         string           `json:"error,omitempty"`
+	Errors        []ImportRowError `json:"errors"`
+}
+
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	SKU   string `json:"sku,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+// ReorderProductImagesPayload lists every image of the product in its new order.
+type ReorderProductImagesPayload struct {
+	ImageIDs []int `json:"imageIDs" validate:"required,min=1"`
 }
 
 type UpdateProductPayload struct {
-	Name        *string  `json:"name,omitempty"`
-	Description *string  `json:"description,omitempty"`
-	Image       *string  `json:"image,omitempty"`
-	Price       *float64 `json:"price,omitempty"`
-	Quantity    *int     `json:"quantity,omitempty"`
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Image       *string `json:"image,omitempty"`
+	Price       *Money  `json:"price,omitempty" validate:"omitempty,gt=0"`
+	Quantity    *int    `json:"quantity,omitempty"`
+}
+
+type CreateCategoryPayload struct {
+	Name        string `json:"name" validate:"required"`
+	Slug        string `json:"slug,omitempty"`
+	Description string `json:"description"`
+	ParentID    *int   `json:"parentID,omitempty"`
+}
+
+// UpdateCategoryPayload moves a category to the top level when ParentID is 0.
+type UpdateCategoryPayload struct {
+	Name        *string `json:"name,omitempty"`
+	Slug        *string `json:"slug,omitempty"`
+	Description *string `json:"description,omitempty"`
+	ParentID    *int    `json:"parentID,omitempty"`
+}
+
+type CategoryProductsPayload struct {
+	ProductIDs []int `json:"productIDs" validate:"required,min=1"`
+}
+
+type SetProductOptionsPayload struct {
+	Options []ProductOptionPayload `json:"options" validate:"dive"`
+}
+
+type ProductOptionPayload struct {
+	Name   string   `json:"name" validate:"required"`
+	Values []string `json:"values" validate:"required,min=1,dive,required"`
+}
+
+type CreateVariantPayload struct {
+	SKU      string            `json:"sku" validate:"required"`
+	Price    *Money            `json:"price,omitempty" validate:"omitempty,gt=0"`
+	Quantity int               `json:"quantity" validate:"gte=0"`
+	Options  map[string]string `json:"options"`
+}
+
+// UpdateVariantPayload clears the price override when Price is 0.
+type UpdateVariantPayload struct {
+	SKU      *string           `json:"sku,omitempty"`
+	Price    *Money            `json:"price,omitempty" validate:"omitempty,gte=0"`
+	Quantity *int              `json:"quantity,omitempty" validate:"omitempty,gte=0"`
+	Options  map[string]string `json:"options,omitempty"`
+}
+
+type CreatePromotionPayload struct {
+	Code          string     `json:"code" validate:"required,max=64"`
+	Description   string     `json:"description"`
+	Type          string     `json:"type" validate:"required,oneof=percentage fixed_amount free_shipping buy_x_get_y"`
+	Percentage    int        `json:"percentage,omitempty" validate:"omitempty,min=1,max=100"`
+	Amount        *Money     `json:"amount,omitempty" validate:"omitempty,gt=0"`
+	BuyQuantity   int        `json:"buyQuantity,omitempty" validate:"omitempty,min=1"`
+	GetQuantity   int        `json:"getQuantity,omitempty" validate:"omitempty,min=1"`
+	ProductIDs    []int      `json:"productIDs"`
+	MinOrderValue *Money     `json:"minOrderValue,omitempty" validate:"omitempty,gt=0"`
+	UsageLimit    *int       `json:"usageLimit,omitempty" validate:"omitempty,min=1"`
+	PerUserLimit  *int       `json:"perUserLimit,omitempty" validate:"omitempty,min=1"`
+	StartsAt      *time.Time `json:"startsAt,omitempty"`
+	EndsAt        *time.Time `json:"endsAt,omitempty"`
+	Active        *bool      `json:"active,omitempty"`
+}
+
+// UpdatePromotionPayload can't change the code or type of a promotion. Setting
+// a limit or the minimum order value to 0 removes it.
+type UpdatePromotionPayload struct {
+	Description   *string    `json:"description,omitempty"`
+	Percentage    *int       `json:"percentage,omitempty" validate:"omitempty,min=1,max=100"`
+	Amount        *Money     `json:"amount,omitempty" validate:"omitempty,gt=0"`
+	BuyQuantity   *int       `json:"buyQuantity,omitempty" validate:"omitempty,min=1"`
+	GetQuantity   *int       `json:"getQuantity,omitempty" validate:"omitempty,min=1"`
+	ProductIDs    []int      `json:"productIDs,omitempty"`
+	MinOrderValue *Money     `json:"minOrderValue,omitempty" validate:"omitempty,gte=0"`
+	UsageLimit    *int       `json:"usageLimit,omitempty" validate:"omitempty,gte=0"`
+	PerUserLimit  *int       `json:"perUserLimit,omitempty" validate:"omitempty,gte=0"`
+	StartsAt      *time.Time `json:"startsAt,omitempty"`
+	EndsAt        *time.Time `json:"endsAt,omitempty"`
+	Active        *bool      `json:"active,omitempty"`
+}
+
+type CreateReturnPayload struct {
+	Reason string              `json:"reason" validate:"required,max=1000"`
+	Items  []ReturnItemPayload `json:"items" validate:"required,min=1,dive"`
+}
+
+type ReturnItemPayload struct {
+	OrderItemID int `json:"orderItemID" validate:"required"`
+	Quantity    int `json:"quantity" validate:"required,min=1"`
+}
+
+type ReviewReturnPayload struct {
+	Note string `json:"note" validate:"max=1000"`
+}
+
+// ReceiveReturnPayload puts the returned items back in stock when Restock is set.
+type ReceiveReturnPayload struct {
+	Note    string `json:"note" validate:"max=1000"`
+	Restock bool   `json:"restock"`
 }
 
 type DeleteProductsPayload struct {
 	Ids []int `json:"ids" validate:"required"`
 }
 
+// UpdateOrderStatusPayload leaves out paid and the refunded statuses, which
+// only a captured payment or a refund can set.
 type UpdateOrderStatusPayload struct {
-	Status string `json:"status" validate:"required"`
+	Status OrderStatus `json:"status" validate:"required,oneof=pending processing shipped delivered cancelled"`
 }
 
 type RegisterUserPayload struct {
@@ -113,11 +880,46 @@ type LoginUserPayload struct {
 	Password string `json:"password" validate:"required"`
 }
 
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+type AssignRolePayload struct {
+	Role string `json:"role" validate:"required"`
+}
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordPayload struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=3,max=130"`
+}
+
+type VerifyEmailPayload struct {
+	Token string `json:"token" validate:"required"`
+}
+
 type GetUserPayload struct {
 	Email string `json:"email" validate:"required,email"`
 }
 
+// CartCheckoutPayload checks out the stored cart when Items is empty.
+// PaymentMethod is the token of the customer's payment method, as issued by
+// the payment provider.
 type CartCheckoutPayload struct {
-	Items   []CartCheckoutItem `json:"items" validate:"required"`
-	Address string             `json:"address" validate:"required"`
+	Items         []CartCheckoutItem `json:"items"`
+	Address       string             `json:"address" validate:"required"`
+	PromotionCode string             `json:"promotionCode,omitempty"`
+	PaymentMethod string             `json:"paymentMethod,omitempty"`
+}
+
+type UpdateCartPayload struct {
+	Items []CartCheckoutItem `json:"items"`
+}
+
+type SetCartItemPayload struct {
+	VariantID int `json:"variantID,omitempty"`
+	Quantity  int `json:"quantity" validate:"gte=0"`
 }
@@ -4,11 +4,36 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"reflect"
+	"strings"
 
+	"github.com/duziem/ecommerce_proj/configs"
+	"github.com/duziem/ecommerce_proj/types"
 	"github.com/go-playground/validator/v10"
 )
 
-var Validate = validator.New()
+const (
+	CartTokenCookie = "cart_token"
+	CartTokenHeader = "X-Cart-Token"
+
+	cartTokenMaxAge = 3600 * 24 * 30
+)
+
+var Validate = newValidator()
+
+// newValidator validates money fields by their amount in minor units, so tags
+// like `validate:"required,gt=0"` work on types.Money.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterCustomTypeFunc(func(field reflect.Value) interface{} {
+		if m, ok := field.Interface().(types.Money); ok {
+			return m.Amount
+		}
+		return nil
+	}, types.Money{})
+
+	return v
+}
 
 func WriteJSON(w http.ResponseWriter, status int, v any) error {
 	w.Header().Add("Content-Type", "application/json")
@@ -42,3 +67,38 @@ func GetTokenFromRequest(r *http.Request) string {
 
 	return ""
 }
+
+// GetCartTokenFromRequest returns the token of an anonymous visitor's cart,
+// sent either as a cookie or a header.
+func GetCartTokenFromRequest(r *http.Request) string {
+	if token := r.Header.Get(CartTokenHeader); token != "" {
+		return token
+	}
+
+	if cookie, err := r.Cookie(CartTokenCookie); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+func SetCartTokenCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CartTokenCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   cartTokenMaxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(configs.Envs.PublicHost, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func ClearCartTokenCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CartTokenCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}